@@ -8,14 +8,14 @@ import (
 )
 
 func main() {
-	vm := forth.NewVM()
+	vm := forth.NewVM(forth.WithPanicRecovery())
 
 	for {
 		err := vm.Run(os.Stdin, os.Stdout)
 		if err == nil {
 			break
 		}
-		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", forth.FormatError(err))
 		vm.ResetState()
 	}
 }
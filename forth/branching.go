@@ -1,30 +1,44 @@
 package forth
 
+import "fmt"
+
 // (branch) branches unconditionally.
-// The int16 relative move is the next word
+// The int32 relative move is the next word
 // in the codeseg.  N.B. because of the way the interpreter
 // runs, we actually compile code to jump to the
 // target IP _minus_ _one_.  N.B. the jump amount is relative
 // to the BRANCH instruction location, NOT the offset number's
 // location.
 func branchUnconditional(vm *VM) (err error) {
-	num := int16(vm.codeseg[vm.ip+1])
+	num := int32(vm.codeseg[vm.ip+1])
 	vm.ip += int(num)
 	// fmt.Printf("Branch to %v\n", vm.ip + 1)
 	if vm.ip < -1 || vm.ip >= len(vm.codeseg) {
-		err = ErrBadState
+		err = &StateError{Word: "(branch)", Reason: "branch target out of range"}
 	}
 	return
 }
 
+// unresolved is the relative move a forward branch gets until
+// the control structure it belongs to is finished
+const unresolved = 1 << 31
+
+// branchTarget gives the address a branch at `pos' goes to,
+// given its relative move `rel'
+func branchTarget(pos int, rel uint32) int {
+	return pos + int(int32(rel)) + 1
+}
+
 // (bzr) branches when the top of stack is zero. Otherwise
-// it is a NOP.  The int16 relative move is the next word
+// it is a NOP.  The int32 relative move is the next word
 // in the codeseg.  N.B. because of the way the interpreter
 // runs, we actually compile code to jump to the
 // target IP _minus_ _one_.
 func branchZero(vm *VM) (err error) {
 	var tos interface{}
-	tos, err = vm.Pop()
+	if tos, err = vm.pop("(bzr)"); err != nil {
+		return
+	}
 	bval, ok := tos.(int)
 	if ok && bval == 0 {
 		err = branchUnconditional(vm)
@@ -34,44 +48,93 @@ func branchZero(vm *VM) (err error) {
 	return
 }
 
-// IF is an immediate word that stores a fixup address
-// on the stack for ELSE / THEN to find, and stores
-// a (bzr) with a dummy branch amount in the code stream.
-func opIf(vm *VM) (err error) {
-	vm.Push(len(vm.codeseg) + 1)
-	vm.codeseg = append(vm.codeseg, opBZR, 32768)
-	return
+// the kinds of control structure on the control-flow stack
+const (
+	ctlIf    = iota // an IF, waiting for its ELSE or THEN
+	ctlElse         // an ELSE, waiting for its THEN
+	ctlWhile        // a WHILE, waiting for its REPEAT
+	ctlBegin        // a BEGIN, for UNTIL, AGAIN or REPEAT to go back to
+	ctlDo           // a DO, waiting for its LOOP or +LOOP
+)
+
+// ctlNames are the words which open each kind of control structure
+var ctlNames = [...]string{ctlIf: "if", ctlElse: "else", ctlWhile: "while", ctlBegin: "begin", ctlDo: "do"}
+
+// control is a control structure which the definition being compiled
+// hasn't finished yet.  They are kept on a stack of their own, away
+// from the data stack, so nothing else can disturb them.
+type control struct {
+	kind int
+	addr int // the branch offset to fix up, or the address BEGIN left
+	pos  Pos // the word which opened it
 }
 
-// THEN takes a fixup address from the stack, and
-// inserts the right amount to jump over the IF (or ELSE)
-// block. No new code is added to the codestream.
-func opThen(vm *VM) (err error) {
-	var tos interface{}
-	tos, err = vm.Pop()
-	fixupLoc, ok := tos.(int)
-	if ok {
-		// 5    6     7       8   // fixupLoc == 6
-		// BZR  FFFF  PRINT       // Right answer == 2  (8 - 6)
-		vm.codeseg[fixupLoc] = uint16(len(vm.codeseg) - fixupLoc)
-	} else {
-		if err == nil {
-			err = ErrBadState
+// pushControl opens a control structure of the given kind
+func (vm *VM) pushControl(kind, addr int) {
+	vm.cstack = append(vm.cstack, control{kind: kind, addr: addr, pos: vm.tok})
+}
+
+// popControl closes the innermost control structure for `word',
+// which has to be one of `kinds'
+func (vm *VM) popControl(word string, kinds ...int) (control, error) {
+	want := make([]string, len(kinds))
+	for i, k := range kinds {
+		want[i] = ctlNames[k]
+	}
+	l := len(vm.cstack) - 1
+	if l < 0 {
+		return control{}, &StructureError{Word: word, Want: want}
+	}
+	c := vm.cstack[l]
+	for _, k := range kinds {
+		if c.kind == k {
+			vm.cstack = vm.cstack[:l]
+			return c, nil
 		}
 	}
+	return control{}, &StructureError{Word: word, Want: want, Open: ctlNames[c.kind], Pos: c.pos}
+}
+
+// resolveFixup fixes up the forward branch offset at `fixupLoc' to
+// jump to the end of the code
+func (vm *VM) resolveFixup(fixupLoc int) {
+	// 5    6     7       8   // fixupLoc == 6
+	// BZR  FFFF  PRINT       // Right answer == 2  (8 - 6)
+	vm.codeseg[fixupLoc] = uint32(len(vm.codeseg) - fixupLoc)
+}
+
+// IF is an immediate word that opens a control structure for
+// ELSE / THEN to find, and stores a (bzr) with a dummy branch
+// amount in the code stream.
+func opIf(vm *VM) (err error) {
+	vm.pushControl(ctlIf, len(vm.codeseg)+1)
+	vm.codeseg = append(vm.codeseg, opBZR, unresolved)
 	return
 }
 
+// THEN closes an IF or ELSE, and inserts the right amount to
+// jump over the IF (or ELSE) block. No new code is added to
+// the codestream.
+func opThen(vm *VM) error {
+	c, err := vm.popControl("then", ctlIf, ctlElse)
+	if err == nil {
+		vm.resolveFixup(c.addr)
+	}
+	return err
+}
+
 // ELSE needs to issue a jump over the else-stuff, and then
-// use opThen to fixup the IF to jump into the else-stuff.
-// Finally, it needs to leave a fixup location on the stack
-// for the final THEN.
-func opElse(vm *VM) (err error) {
-	fupLoc := len(vm.codeseg) + 1
-	vm.codeseg = append(vm.codeseg, opBranch, 32768)
-	err = opThen(vm)
-	vm.Push(fupLoc)
-	return
+// fix up the IF to jump into the else-stuff.  Finally, it
+// leaves a structure of its own for the final THEN.
+func opElse(vm *VM) error {
+	c, err := vm.popControl("else", ctlIf)
+	if err != nil {
+		return err
+	}
+	vm.pushControl(ctlElse, len(vm.codeseg)+1)
+	vm.codeseg = append(vm.codeseg, opBranch, unresolved)
+	vm.resolveFixup(c.addr)
+	return nil
 }
 
 // RECUR just jumps to the start of the current function
@@ -79,7 +142,77 @@ func recur(vm *VM) (err error) {
 	// 5     6      7      8      // Start = 5  len(code) == 8
 	// PRINT PRINT  PRINT  RECUR  // Right answer ==  -4 (5 - 8 - 1)
 	distance := vm.curdef - len(vm.codeseg) - 1
-	vm.codeseg = append(vm.codeseg, opBranch, uint16(distance))
+	vm.codeseg = append(vm.codeseg, opBranch, uint32(distance))
+	return
+}
+
+// BEGIN marks the destination for a backward branch from
+// UNTIL, AGAIN, or REPEAT.
+func opBegin(vm *VM) (err error) {
+	vm.pushControl(ctlBegin, len(vm.codeseg))
+	return
+}
+
+// compileBack adds a branch of type `op' back to `dest'
+func compileBack(vm *VM, op uint32, dest int) {
+	// 5     6      7      8      // dest = 5  len(code) == 8
+	// DUP   DROP   BZR    -4     // Right answer ==  -4 (5 - 8 - 1)
+	distance := dest - len(vm.codeseg) - 1
+	vm.codeseg = append(vm.codeseg, op, uint32(distance))
+}
+
+// UNTIL branches back to the BEGIN while the top of the stack is zero
+func opUntil(vm *VM) error {
+	c, err := vm.popControl("until", ctlBegin)
+	if err == nil {
+		compileBack(vm, opBZR, c.addr)
+	}
+	return err
+}
+
+// AGAIN branches back to the BEGIN forever
+func opAgain(vm *VM) error {
+	c, err := vm.popControl("again", ctlBegin)
+	if err == nil {
+		compileBack(vm, opBranch, c.addr)
+	}
+	return err
+}
+
+// WHILE leaves the loop when the top of the stack is zero. Like IF,
+// it leaves a fixup, but it tucks it under the BEGIN for REPEAT to
+// find.
+func opWhile(vm *VM) error {
+	begin, err := vm.popControl("while", ctlBegin)
+	if err != nil {
+		return err
+	}
+	vm.pushControl(ctlWhile, len(vm.codeseg)+1)
+	vm.codeseg = append(vm.codeseg, opBZR, unresolved)
+	vm.cstack = append(vm.cstack, begin)
+	return nil
+}
+
+// REPEAT branches back to the BEGIN, and fixes up the WHILE
+// to jump past the loop.
+func opRepeat(vm *VM) error {
+	begin, err := vm.popControl("repeat", ctlBegin)
+	if err != nil {
+		return err
+	}
+	while, err := vm.popControl("repeat", ctlWhile)
+	if err != nil {
+		vm.cstack = append(vm.cstack, begin)
+		return err
+	}
+	compileBack(vm, opBranch, begin.addr)
+	vm.resolveFixup(while.addr)
+	return nil
+}
+
+// EXIT returns from the current word early
+func opExit(vm *VM) (err error) {
+	vm.codeseg = append(vm.codeseg, opReturn)
 	return
 }
 
@@ -90,10 +223,8 @@ func recur(vm *VM) (err error) {
 // of the loop:
 // >r >r (test loop-body back-facing branch) rdrop rdrop
 func opDo(vm *VM) (err error) {
-	opSetup := vm.dict["(setupDo)"]
-	opTest := vm.dict["(testDo)"]
-	vm.codeseg = append(vm.codeseg, opSetup, opTest, 32768)
-	vm.Push(len(vm.codeseg) - 1)
+	vm.codeseg = append(vm.codeseg, opSetupDo, opTestDo, unresolved)
+	vm.pushControl(ctlDo, len(vm.codeseg)-1)
 	return
 }
 
@@ -105,20 +236,18 @@ func opLoopPlus(vm *VM) error {
 }
 
 func opLoopInternal(vm *VM, pullVal bool) (err error) {
-	opLoopPlus := vm.dict["(perfLoopPlus)"]
 	opRAt := vm.dict["r@"]
 	opRDrop := vm.dict["rdrop"]
 
-	var fixUpLoc interface{}
-	fixUpLoc, err = vm.Pop()
+	word := "+loop"
+	if pullVal {
+		word = "loop"
+	}
+	c, err := vm.popControl(word, ctlDo)
 	if err != nil {
 		return
 	}
-
-	ful, ok := fixUpLoc.(int)
-	if !ok {
-		err = ErrBadState
-	}
+	ful := c.addr
 
 	distToEnd := len(vm.codeseg) + 3 - ful
 	distToStart := ful - len(vm.codeseg) - 3
@@ -128,9 +257,9 @@ func opLoopInternal(vm *VM, pullVal bool) (err error) {
 		distToEnd++
 		distToStart--
 	}
-	vm.codeseg[ful] = uint16(distToEnd)
-	vm.codeseg = append(vm.codeseg, opLoopPlus,
-		opBranch, uint16(distToStart),
+	vm.codeseg[ful] = uint32(distToEnd)
+	vm.codeseg = append(vm.codeseg, opPerfLoopPlus,
+		opBranch, uint32(distToStart),
 		opRDrop, opRDrop, opRDrop)
 	return
 }
@@ -139,35 +268,27 @@ func opLoopInternal(vm *VM, pullVal bool) (err error) {
 func performLoopPlus(vm *VM) (err error) {
 	rtop := len(vm.Rstack) - 1
 	if rtop < 2 {
-		return ErrUnderflow
+		return vm.rUnderflow("+loop", 3)
 	}
 	ridx := vm.Rstack[rtop-2]
 	iidx, ok := ridx.(int)
-
-	var amt interface{}
-	amt, err = vm.Pop()
-	if err != nil {
-		return err
+	if !ok {
+		return typeError("+loop", "an int loop index", ridx)
 	}
 
-	iamt, ok2 := amt.(int)
-	if ok && ok2 {
+	iamt, err := vm.popInt("+loop")
+	if err == nil {
 		vm.Rstack[rtop-2] = (iamt + iidx)
-	} else {
-		err = ErrBadState
 	}
 	return
 }
 
 func setupDo(vm *VM) (err error) {
-	err = toR(vm)
-	if err != nil {
-		return err
-	}
-	err = toR(vm)
-	if err != nil {
-		return err
+	if len(vm.Stack) < 2 {
+		return vm.underflow("do", 2)
 	}
+	_ = toR(vm)
+	_ = toR(vm)
 	rtop := len(vm.Rstack) - 1
 	rlim, ridx := vm.Rstack[rtop], vm.Rstack[rtop-1]
 	limval, ok1 := rlim.(int)
@@ -182,7 +303,7 @@ func setupDo(vm *VM) (err error) {
 			vm.RPush(0)
 		}
 	} else {
-		err = ErrBadState
+		err = &TypeError{Word: "do", Want: "int limit and start", Got: fmt.Sprintf("%T and %T", rlim, ridx)}
 	}
 	return
 }
@@ -190,7 +311,7 @@ func setupDo(vm *VM) (err error) {
 func testDo(vm *VM) (err error) {
 	rtop := len(vm.Rstack) - 1
 	if rtop < 2 {
-		return ErrUnderflow
+		return vm.rUnderflow("(testDo)", 3)
 	}
 	rtest, rlim, ridx := vm.Rstack[rtop], vm.Rstack[rtop-1], vm.Rstack[rtop-2]
 	testval, ok1 := rtest.(int)
@@ -208,7 +329,7 @@ func testDo(vm *VM) (err error) {
 			noloop = ival <= limval
 		}
 	} else {
-		err = ErrBadState
+		return &StateError{Word: "(testDo)", Reason: "the loop parameters on the r-stack are corrupt"}
 	}
 	if noloop {
 		err = branchUnconditional(vm)
@@ -221,7 +342,7 @@ func testDo(vm *VM) (err error) {
 func getDoI(vm *VM) error {
 	rlen := len(vm.Rstack)
 	if rlen < 3 {
-		return ErrUnderflow
+		return vm.rUnderflow("i", 3)
 	}
 	vm.Push(vm.Rstack[rlen-3])
 	return nil
@@ -230,23 +351,26 @@ func getDoI(vm *VM) error {
 func getDoJ(vm *VM) error {
 	rlen := len(vm.Rstack)
 	if rlen < 6 {
-		return ErrUnderflow
+		return vm.rUnderflow("j", 6)
 	}
 	vm.Push(vm.Rstack[rlen-6])
 	return nil
 }
 
 func branchWordsInit(vm *VM) {
-	vm.Define("if", Word{opIf, true})
-	vm.Define("else", Word{opElse, true})
-	vm.Define("then", Word{opThen, true})
-	vm.Define("recur", Word{recur, true})
-	vm.Define("do", Word{opDo, true})
-	vm.Define("(setupDo)", Word{setupDo, false})
-	vm.Define("(testDo)", Word{testDo, false})
-	vm.Define("(perfLoopPlus)", Word{performLoopPlus, false})
-	vm.Define("loop", Word{opLoop, true})
-	vm.Define("+loop", Word{opLoopPlus, true})
-	vm.Define("i", Word{getDoI, false})
-	vm.Define("j", Word{getDoJ, false})
+	vm.Define("if", Word{Run: opIf, Immediate: true, CompileOnly: true, Effect: "( flag -- )", Doc: "runs the code up to ELSE or THEN when flag is non-zero"})
+	vm.Define("else", Word{Run: opElse, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "starts the code to run when the IF flag was zero"})
+	vm.Define("then", Word{Run: opThen, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "ends an IF or IF ... ELSE structure"})
+	vm.Define("recur", Word{Run: recur, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "jumps back to the start of the word being defined"})
+	vm.Define("do", Word{Run: opDo, Immediate: true, CompileOnly: true, Effect: "( limit start -- )", Doc: "starts a counted loop, ended by LOOP or +LOOP"})
+	vm.Define("loop", Word{Run: opLoop, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "adds one to the loop index, and loops until it reaches the limit"})
+	vm.Define("+loop", Word{Run: opLoopPlus, Immediate: true, CompileOnly: true, Effect: "( n -- )", Doc: "adds n to the loop index, and loops until it reaches the limit"})
+	vm.Define("begin", Word{Run: opBegin, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "starts an UNTIL, AGAIN, or WHILE ... REPEAT loop"})
+	vm.Define("until", Word{Run: opUntil, Immediate: true, CompileOnly: true, Effect: "( flag -- )", Doc: "loops back to BEGIN until flag is non-zero"})
+	vm.Define("again", Word{Run: opAgain, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "loops back to BEGIN forever"})
+	vm.Define("while", Word{Run: opWhile, Immediate: true, CompileOnly: true, Effect: "( flag -- )", Doc: "leaves the BEGIN loop when flag is zero"})
+	vm.Define("repeat", Word{Run: opRepeat, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "loops back to BEGIN, ending a WHILE loop"})
+	vm.Define("exit", Word{Run: opExit, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "returns from the current word"})
+	vm.Define("i", Word{Run: getDoI, Effect: "( -- n )", Doc: "gives the index of the innermost DO loop"})
+	vm.Define("j", Word{Run: getDoJ, Effect: "( -- n )", Doc: "gives the index of the next-outer DO loop"})
 }
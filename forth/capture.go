@@ -0,0 +1,144 @@
+package forth
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// capture is an output redirection into a string, along
+// with the output it replaced.
+type capture struct {
+	buf     *strings.Builder
+	saved   io.Writer
+	max     int  // the string limit, or zero
+	refused bool // was output dropped for going past the limit?
+}
+
+// Write adds to the captured output, unless that would take it past
+// the string limit, in which case nothing is written.
+func (c *capture) Write(p []byte) (int, error) {
+	if c.max > 0 && c.buf.Len()+len(p) > c.max {
+		c.refused = true
+		return 0, &LimitError{Err: ErrStringLimit, Max: c.max}
+	}
+	return c.buf.Write(p)
+}
+
+// beginCapture starts sending output into a fresh buffer.
+// Captures nest, and each one has to be ended with endCapture.
+func (vm *VM) beginCapture() {
+	c := &capture{buf: new(strings.Builder), saved: vm.out, max: vm.limits.String}
+	vm.captures = append(vm.captures, c)
+	vm.out = c
+}
+
+// refusedOutput tells whether the innermost capture had output it
+// couldn't hold.  Words which print mostly don't look at errors from
+// writing, so this lets the limits catch it after the word has run.
+func (vm *VM) refusedOutput() bool {
+	l := len(vm.captures)
+	return l > 0 && vm.captures[l-1].refused
+}
+
+// endCapture stops the innermost capture, restoring the output it
+// replaced, and gives back what was written.
+func (vm *VM) endCapture(word string) (string, error) {
+	l := len(vm.captures) - 1
+	if l < 0 {
+		return "", &StateError{Word: word, Reason: "no output capture in progress"}
+	}
+	c := vm.captures[l]
+	vm.captures = vm.captures[:l]
+	vm.out = c.saved
+	return c.buf.String(), nil
+}
+
+// <capture starts capturing output, until a matching capture>
+func startCapture(vm *VM) error {
+	vm.beginCapture()
+	return nil
+}
+
+// capture> ( -- str ) ends the innermost capture, giving the
+// output as a string.
+func stopCapture(vm *VM) error {
+	str, err := vm.endCapture("capture>")
+	if err == nil {
+		vm.Push(str)
+	}
+	return err
+}
+
+// with-output-to-string ( xt -- str ) runs the execution token
+// with its output captured into a string.
+func withOutputToString(vm *VM) error {
+	xt, err := vm.popXT("with-output-to-string")
+	if err != nil {
+		return err
+	}
+	// the word runs to the end before the capture does, even one
+	// like `execute' which would otherwise hand a word to the inner
+	// interpreter to run later
+	inner := vm.inner
+	vm.beginCapture()
+	err = vm.dispatch(xt, false)
+	vm.inner = inner
+	str, cerr := vm.endCapture("with-output-to-string")
+	if err != nil {
+		return err
+	}
+	if cerr == nil {
+		vm.Push(str)
+	}
+	return cerr
+}
+
+// builder ( -- b ) pushes a new, empty, string builder.
+func newBuilder(vm *VM) error {
+	vm.Push(new(strings.Builder))
+	return nil
+}
+
+// b+ ( b x -- b ) adds x to the builder, written like `type' would.
+func builderAppend(vm *VM) error {
+	top := len(vm.Stack) - 1
+	if top < 1 {
+		return vm.underflow("b+", 2)
+	}
+	sb, ok := vm.Stack[top-1].(*strings.Builder)
+	if !ok {
+		return typeError("b+", "a string builder", vm.Stack[top-1])
+	}
+	str := fmt.Sprint(vm.Stack[top])
+	if err := vm.stringFits("b+", sb.Len()+len(str), 1); err != nil {
+		return err
+	}
+	sb.WriteString(str)
+	vm.Stack = vm.Stack[:top]
+	return nil
+}
+
+// b>str ( b -- str ) gives the contents of the builder.
+func builderString(vm *VM) error {
+	v, err := vm.pop("b>str")
+	if err != nil {
+		return err
+	}
+	sb, ok := v.(*strings.Builder)
+	if !ok {
+		return typeError("b>str", "a string builder", v)
+	}
+	vm.Push(sb.String())
+	return nil
+}
+
+// captureWordsInit adds the output capturing words to the VM
+func captureWordsInit(vm *VM) {
+	vm.Define("<capture", Word{Run: startCapture, Effect: "( -- )", Doc: "starts capturing output, until CAPTURE>"})
+	vm.Define("capture>", Word{Run: stopCapture, Effect: "( -- str )", Doc: "ends the innermost output capture, giving what was written"})
+	vm.Define("with-output-to-string", Word{Run: withOutputToString, Effect: "( xt -- str )", Doc: "runs xt, giving its output as a string"})
+	vm.Define("builder", Word{Run: newBuilder, Effect: "( -- b )", Doc: "makes a new, empty, string builder"})
+	vm.Define("b+", Word{Run: builderAppend, Effect: "( b x -- b )", Doc: "appends x to the builder, written like TYPE would"})
+	vm.Define("b>str", Word{Run: builderString, Effect: "( b -- str )", Doc: "gives the contents of a string builder"})
+}
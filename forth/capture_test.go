@@ -0,0 +1,28 @@
+package forth
+
+import "testing"
+
+func TestCapture(t *testing.T) {
+	tstRunForth(t, `: greet " hi" type 2 . ; ' greet with-output-to-string`, "hi2 ")
+	tstRunForth(t, `<capture 1 . <capture 2 . capture> 3 . capture>`, "2 ", "1 3 ")
+	tstRunForth(t, `: report <capture " total: " type . capture> ; 7 report`, "total: 7 ")
+	for _, opts := range [][]Option{nil, {WithThreadedCode()}} {
+		fvm := NewVM(opts...)
+		code := `: g 5 . ; : f ['] g ['] execute with-output-to-string 99 ; f`
+		if out := tstOutputOf(t, fvm, code); out != "" || len(fvm.Stack) != 2 || fvm.Stack[0] != "5 " {
+			t.Errorf("capturing execute: wrote %q, left %v", out, fvm.Stack)
+		}
+	}
+
+	out, _ := tstOutput(t, `1 . <capture 2 . capture> drop 3 .`)
+	if out != "1 3 " {
+		t.Errorf("captured output leaked: %q", out)
+	}
+	if e := tstError(t, `capture>`); e.Word != "capture>" {
+		t.Errorf("wrong error: %v", e)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tstRunForth(t, `builder " a=" b+ 1 b+ " , b=" b+ 2.5 b+ b>str`, "a=1, b=2.5")
+}
@@ -0,0 +1,229 @@
+package forth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WordInfo describes a word in the dictionary
+type WordInfo struct {
+	Name string
+	XT   int // the execution token, as used by `execute'
+	Word
+}
+
+// Words gives every word that can be found by name, in the order
+// they were defined.
+func (vm *VM) Words() []WordInfo {
+	ans := make([]WordInfo, 0, len(vm.dict))
+	for name := range vm.dict {
+		if xt, ok := vm.findWord(name); ok {
+			ans = append(ans, WordInfo{Name: name, XT: int(xt), Word: vm.words[xt]})
+		}
+	}
+	sort.Slice(ans, func(i, j int) bool { return ans[i].XT < ans[j].XT })
+	return ans
+}
+
+// findWord looks up `name' in the dictionary.  A hidden word can't
+// be found, but the definition it shadowed can.
+func (vm *VM) findWord(name string) (uint32, bool) {
+	xt, ok := vm.dict[name]
+	for ok && vm.words[xt].Hidden {
+		prev := vm.words[xt].prev
+		xt, ok = uint32(prev), prev >= 0 && prev < len(vm.words)
+	}
+	return xt, ok
+}
+
+// Lookup finds the word called `name'
+func (vm *VM) Lookup(name string) (WordInfo, bool) {
+	xt, ok := vm.findWord(strings.ToLower(name))
+	if !ok {
+		return WordInfo{}, false
+	}
+	return WordInfo{Name: vm.words[xt].name, XT: int(xt), Word: vm.words[xt]}, true
+}
+
+// See gives the definition of the word called `name', decompiled
+// back into forth.  Words written in Go just get described.
+func (vm *VM) See(name string) (string, error) {
+	xt, ok := vm.findWord(strings.ToLower(name))
+	if !ok {
+		return "", &UnknownWordError{Name: name}
+	}
+	w := vm.words[xt]
+	if w.body == nil {
+		return fmt.Sprintf("%s is written in Go", w.name), nil
+	}
+	return vm.decompile(w), nil
+}
+
+// Help describes the word called `name': its stack effect, what it
+// does, and where it was defined.
+func (vm *VM) Help(name string) (string, error) {
+	w, ok := vm.Lookup(name)
+	if !ok {
+		return "", &UnknownWordError{Name: name}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(w.Name)
+	if w.Effect != "" {
+		sb.WriteString(" ")
+		sb.WriteString(w.Effect)
+	}
+	fmt.Fprintf(&sb, "  [%s]", w.Vocab)
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{w.Immediate, "immediate"},
+		{w.CompileOnly, "compile-only"},
+		{w.InterpretOnly, "interpret-only"},
+		{w.Deprecated, "deprecated"},
+	} {
+		if flag.set {
+			sb.WriteString(" " + flag.name)
+		}
+	}
+	sb.WriteString("\n")
+	if w.Doc != "" {
+		fmt.Fprintf(&sb, "    %s\n", w.Doc)
+	}
+	if w.File != "" {
+		fmt.Fprintf(&sb, "    defined at %s:%d\n", w.File, w.Line)
+	}
+	return sb.String(), nil
+}
+
+// printNames writes out the names of words, newest first,
+// wrapping the lines.
+func (vm *VM) printNames(ws []WordInfo) {
+	col := 0
+	for i := len(ws) - 1; i >= 0; i-- {
+		name := ws[i].Name
+		if col > 0 && col+len(name) >= 72 {
+			fmt.Fprintln(vm.out)
+			col = 0
+		}
+		if col > 0 {
+			fmt.Fprint(vm.out, " ")
+			col++
+		}
+		fmt.Fprint(vm.out, name)
+		col += len(name)
+	}
+	if col > 0 {
+		fmt.Fprintln(vm.out)
+	}
+}
+
+// words prints the names of all words
+func words(vm *VM) error {
+	vm.printNames(vm.Words())
+	return nil
+}
+
+// words-in <vocab> prints the names of the words in a vocabulary
+func wordsIn(vm *VM) error {
+	vocab, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "words-in", Reason: "no vocabulary given"}
+	}
+	var ws []WordInfo
+	for _, w := range vm.Words() {
+		if w.Vocab == vocab {
+			ws = append(ws, w)
+		}
+	}
+	vm.printNames(ws)
+	return nil
+}
+
+// vocab <name> puts new definitions in the named vocabulary
+func vocab(vm *VM) error {
+	name, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "vocab", Reason: "no vocabulary given"}
+	}
+	vm.vocab = name
+	return nil
+}
+
+// find ( str -- xt 1 | xt -1 | str 0 ) looks up a word by name.  Like
+// the ANS word, it gives 1 for immediate words and -1 for the rest.
+func find(vm *VM) error {
+	name, err := vm.popString("find")
+	if err != nil {
+		return err
+	}
+	xt, ok := vm.findWord(strings.ToLower(name))
+	switch {
+	case !ok:
+		vm.Push(name)
+		vm.Push(0)
+	case vm.words[xt].Immediate:
+		vm.Push(int(xt))
+		vm.Push(1)
+	default:
+		vm.Push(int(xt))
+		vm.Push(-1)
+	}
+	return nil
+}
+
+// apropos <text> prints the words with names containing the text
+func apropos(vm *VM) error {
+	text, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "apropos", Reason: "nothing to search for"}
+	}
+	for _, w := range vm.Words() {
+		if strings.Contains(w.Name, text) {
+			fmt.Fprintf(vm.out, "%s (%s)\n", w.Name, w.Vocab)
+		}
+	}
+	return nil
+}
+
+// see <name> prints the definition of a word
+func see(vm *VM) error {
+	name, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "see", Reason: "no word given"}
+	}
+	def, err := vm.See(name)
+	if err != nil {
+		return vm.unknownWord(err, name, vm.tok)
+	}
+	fmt.Fprintln(vm.out, def)
+	return nil
+}
+
+// help <name> prints the documentation for a word
+func help(vm *VM) error {
+	name, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "help", Reason: "no word given"}
+	}
+	text, err := vm.Help(name)
+	if err != nil {
+		return vm.unknownWord(err, name, vm.tok)
+	}
+	fmt.Fprint(vm.out, text)
+	return nil
+}
+
+// dictWordsInit adds the words for looking at the dictionary to the VM
+func dictWordsInit(vm *VM) {
+	vm.Define("words", Word{Run: words, Effect: "( -- )", Doc: "prints the names of all words, newest first"})
+	vm.Define("words-in", Word{Run: wordsIn, Effect: "( \"vocab\" -- )", Doc: "prints the names of the words in a vocabulary"})
+	vm.Define("vocab", Word{Run: vocab, Effect: "( \"name\" -- )", Doc: "puts new definitions in the named vocabulary"})
+	vm.Define("find", Word{Run: find, Effect: "( str -- xt 1 | xt -1 | str 0 )", Doc: "looks up a word by name, giving 1 if it is immediate"})
+	vm.Define("apropos", Word{Run: apropos, Effect: "( \"text\" -- )", Doc: "prints the words whose names contain the text"})
+	vm.Define("help", Word{Run: help, Effect: "( \"name\" -- )", Doc: "prints the documentation for a word"})
+	vm.Define("see", Word{Run: see, Effect: "( \"name\" -- )", Doc: "prints the definition of a word"})
+	vm.Define("verify", Word{Run: verify, Effect: "( -- )", Doc: "checks that the code of every definition is safe to run"})
+}
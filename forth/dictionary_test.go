@@ -0,0 +1,192 @@
+package forth
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSee(t *testing.T) {
+	defs := []string{
+		`: sq dup * ;`,
+		`: choose 0 > if 1 else 2 then ;`,
+		`: nested if if 1 then else 2 then 3 ;`,
+		`: count 10 0 do i . loop ;`,
+		`: evens 10 0 do i . 2 +loop ;`,
+		`: down begin dup while 1 - repeat ;`,
+		`: down2 begin 1 - dup 0 = until ;`,
+		`: forever 1 begin 1 + again ;`,
+		`: lits " hi there" 2.5 3.0 100000 -7 ;`,
+		`: early 1 exit 2 ;`,
+		`: mac postpone dup postpone if ; immediate`,
+		`: again2 dup 0 > if 1 - recur then ;`,
+	}
+	for _, def := range defs {
+		tstRunForth(t, def)
+		name := strings.Fields(def)[1]
+		got, err := vm.See(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := def
+		switch name {
+		case "again2":
+			// recur at the end of an IF is the same code as a WHILE loop
+			want = `: again2 begin dup 0 > while 1 - repeat ;`
+		case "down2":
+			// the optimizer fuses `0 ='
+			want = `: down2 begin 1 - dup (0=) until ;`
+		case "early":
+			// and drops code which is never run
+			want = `: early 1 ;`
+		}
+		if got != want {
+			t.Errorf("see %s:\n got %s\nwant %s", name, got, want)
+		}
+	}
+
+	out, _ := tstOutput(t, `see dup`)
+	if out != "dup is written in Go\n" {
+		t.Errorf("wrong output for primitive: %q", out)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tstRunForth(t, `vocab tests : lk 1 ; vocab user`)
+	w, ok := vm.Lookup("LK")
+	if !ok || w.Name != "lk" || w.Vocab != "tests" || w.Immediate {
+		t.Errorf("bad lookup: %+v", w)
+	}
+	if w, _ := vm.Lookup("dup"); w.Vocab != "kernel" {
+		t.Errorf("dup is in %s", w.Vocab)
+	}
+	if w, _ := vm.Lookup("nip"); w.Vocab != "core" {
+		t.Errorf("nip is in %s", w.Vocab)
+	}
+
+	ws := vm.Words()
+	for i := 1; i < len(ws); i++ {
+		if ws[i-1].XT >= ws[i].XT {
+			t.Fatalf("words out of order at %d", i)
+		}
+	}
+	if ws[len(ws)-1].Name != "lk" {
+		t.Errorf("newest word is %s", ws[len(ws)-1].Name)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dupXT, _ := vm.Lookup("dup")
+	ifXT, _ := vm.Lookup("if")
+	tstRunForth(t, `" dup" find  " IF" find  " nope" find`, dupXT.XT, -1, ifXT.XT, 1, "nope", 0)
+}
+
+func TestWordLists(t *testing.T) {
+	out, _ := tstOutput(t, `words-in full  words-in core`)
+	if !strings.HasPrefix(out, "spaces space emit max min") || !strings.HasSuffix(out, "2dup tuck nip\n") {
+		t.Errorf("wrong core words: %q", out)
+	}
+	out, _ = tstOutput(t, `apropos rdr`)
+	if out != "rdrop (kernel)\n" {
+		t.Errorf("wrong apropos: %q", out)
+	}
+	out, _ = tstOutput(t, `words`)
+	if !strings.Contains(out, "dup") || !strings.Contains(out, "nip") {
+		t.Errorf("missing words: %q", out)
+	}
+}
+
+func TestWordMetadata(t *testing.T) {
+	tstRunForth(t, ": cube ( n -- n^3 ) doc\" gives the cube\" dup dup * * ;\n: plain 1 ; doc\" documented later\"")
+	w, _ := vm.Lookup("cube")
+	if w.Effect != "( n -- n^3 )" || w.Doc != "gives the cube" || w.File != "<input>" || w.Line != 1 {
+		t.Errorf("wrong metadata: %+v", w)
+	}
+	w, _ = vm.Lookup("plain")
+	if w.Effect != "" || w.Doc != "documented later" || w.Line != 2 {
+		t.Errorf("wrong metadata: %+v", w)
+	}
+	tstRunForth(t, `: notfx 1 ( not an effect ) ;`)
+	if w, _ = vm.Lookup("notfx"); w.Effect != "" {
+		t.Errorf("comment taken as effect: %q", w.Effect)
+	}
+
+	// prelude words get their effects from the source, and Go words
+	// can be given them by Define
+	if w, _ = vm.Lookup("nip"); w.Effect != "( a b -- b )" || w.File != "prelude/core.fs" {
+		t.Errorf("wrong metadata: %+v", w)
+	}
+	vm.Define("host-word", Word{Run: dup, Effect: "( a -- a a )", Doc: "from the host"})
+	if w, _ = vm.Lookup("host-word"); !strings.HasSuffix(w.File, "dictionary_test.go") || w.Doc != "from the host" {
+		t.Errorf("wrong metadata: %+v", w)
+	}
+
+	out, _ := tstOutput(t, "help cube")
+	if out != "cube ( n -- n^3 )  [user]\n    gives the cube\n    defined at <input>:1\n" {
+		t.Errorf("wrong help: %q", out)
+	}
+}
+
+func TestWordFlags(t *testing.T) {
+	old := func(fvm *VM) {
+		fvm.Define("old", Word{Run: func(fvm *VM) error { fvm.Push(1); return nil }, Deprecated: true})
+	}
+	fvm := NewVM(old)
+	for _, c := range []struct{ code, word string }{
+		{"1 if", "if"},
+		{"5 literal", "literal"},
+		{": f : g ;", ":"},
+		{": cw 1 ; compile-only cw", "cw"},
+		{": iw 1 ; interpret-only : f iw ;", "iw"},
+	} {
+		fvm.ResetState()
+		err := fvm.Run(strings.NewReader(c.code), ioutil.Discard)
+		var se *StateError
+		if !errors.As(err, &se) || se.Word != c.word {
+			t.Errorf("%s: expected a StateError from %s, got %v", c.code, c.word, err)
+		}
+	}
+
+	// hidden words can't be found, but what they shadowed can
+	fvm.ResetState()
+	tstOutputOf(t, fvm, `: g 1 ; : g 2 ; hidden : h ; hidden : uses-cw cw ;`)
+	if out := tstOutputOf(t, fvm, `g . uses-cw .`); out != "1 1 " {
+		t.Errorf("wrong output: %q", out)
+	}
+	if _, ok := fvm.Lookup("h"); ok {
+		t.Error("found a hidden word")
+	}
+	for _, w := range fvm.Words() {
+		if w.Name == "h" || w.Name == "(branch)" || w.Name == "(nip)" {
+			t.Errorf("%s is listed", w.Name)
+		}
+	}
+	if err := fvm.Run(strings.NewReader("h"), ioutil.Discard); !errors.Is(err, ErrUnknownWord) {
+		t.Errorf("expected h to be unknown, got %v", err)
+	}
+
+	// deprecated words give a warning wherever they are used
+	var errs bytes.Buffer
+	fvm.SetErrOutput(&errs)
+	fvm.ResetState()
+	if out := tstOutputOf(t, fvm, "old .\n: u old ; u ."); out != "1 1 " {
+		t.Errorf("wrong output: %q", out)
+	}
+	if w := errs.String(); w != "<input>:1:1: warning: old is deprecated\n<input>:2:5: warning: old is deprecated\n" {
+		t.Errorf("wrong warnings: %q", w)
+	}
+
+	// the flags are kept in images
+	loaded, err := tstImage(t, fvm, nil, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := loaded.Lookup("cw"); !ok || !w.CompileOnly {
+		t.Errorf("cw lost its flag: %+v", w)
+	}
+	if _, ok := loaded.Lookup("h"); ok {
+		t.Error("h is not hidden in the image")
+	}
+}
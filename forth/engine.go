@@ -2,8 +2,12 @@ package forth
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"runtime"
 )
 
 // define a few constant opcodes that are reliable
@@ -15,62 +19,179 @@ const (
 	opCompileComma
 	opBranch
 	opBZR
+	opSetupDo
+	opTestDo
+	opPerfLoopPlus
+	opTailCall
+	opLitPOOL
+
+	// the last of the specials, which every VM has in the same place
+	lastSpecial = opLitPOOL
 )
 
-// A Word in forth is an operation on the VM
+// operands gives the number of cells following an opcode in
+// the codeseg which belong to it, rather than being opcodes
+// themselves.
+func operands(op uint32) int {
+	switch op {
+	case opLitINT, opLitUINT, opBranch, opBZR, opTestDo, opTailCall, opLitPOOL:
+		return 1
+	}
+	return 0
+}
+
+// A Word in forth is an operation on the VM. Besides what it
+// does, a word can carry documentation for tools like `help'.
 type Word struct {
 	Run       func(*VM) error
 	Immediate bool
+
+	CompileOnly   bool // it can only be used in a definition
+	InterpretOnly bool // it can't be used in a definition
+	Hidden        bool // it can't be found by name
+	Deprecated    bool // using it gives a warning
+
+	Doc    string // what the word does
+	Effect string // its stack effect, like "( a b -- c )"
+	File   string // the file where it was defined
+	Line   int    // the line in File where it was defined
+	Vocab  string // the vocabulary it was defined in
+	Cost   int    // what running it costs, when metering; zero for the default
+
+	name  string         // the name it was defined with
+	body  *CompositeWord // the code, if it was defined in forth
+	lit   interface{}    // the value, if it is a literal pusher
+	isLit bool
+	prev  int        // the word of the same name this one shadowed, or -1
+	mark  *markState // what to roll back to, if it is a marker
 }
 
 // VM is the forth virtual machine state, which all
 // operations take
 type VM struct {
 	words []Word
-	dict  map[string]uint16 // maps from names to indexes in `words'
+	dict  map[string]uint32 // maps from names to indexes in `words'
 
 	Stack  []interface{} // the data stack
 	Rstack []interface{} // the return stack
 
-	codeseg []uint16 // where the code for composite (user-defined) words go
-	ip      int      // instruction pointer
-	curdef  int      // the start-index of the word we are currently defining
-	curname string   // the name of teh word we are defining
+	codeseg []uint32    // where the code for composite (user-defined) words go
+	srcmap  []Pos       // where in the source each cell of the codeseg came from
+	ip      int         // instruction pointer
+	calls   []callFrame // the composite words being run
+	inner   bool        // was the running word called by the inner interpreter?
+	curdef  int         // the start-index of the word we are currently defining
+	curname string      // the name of teh word we are defining
+	curmeta Word        // the documentation for the word we are defining
+	curundo *undoDef    // how to undo the definition, if it fails
+	cstack  []control   // the unfinished control structures in the definition
+	vocab   string      // the vocabulary new words go into
+
+	curpos    Pos  // where the name of the definition is
+	currebind bool // point callers of the word it replaces at it?
+
+	src         *source         // our input
+	sources     []*source       // the inputs src was included from
+	loaded      map[string]bool // files we have included, for `require'
+	roots       []fs.FS         // filesystems to load files from
+	filesystems []fs.FS         // every filesystem loaded from, for loadKey
+	tok         Pos             // where the last token read came from
+
+	searchPath []string // directories in the roots to look for files
+	prelude    Prelude  // which prelude to load
+
+	Sink    *bufio.Writer // our output
+	ErrSink *bufio.Writer // our error output, for diagnostics
+	out     io.Writer     // where the printing words write right now
+
+	captures []*capture // output captures in progress
+
+	marker markState // place to roll back to when we FORGET
+
+	pool      []interface{}          // the literals which don't fit in a cell
+	poolIndex map[interface{}]uint32 // where each literal is in the pool
+
+	limits              Limits          // what the code we run is allowed to do
+	limited             bool            // are there any limits to check at each step?
+	steps               int             // steps taken in this run, for limits.Steps
+	lastCode, lastWords int             // sizes at the last step, to see what grew
+	ctx                 context.Context // cancels the run, from RunContext
+
+	costs    Costs   // the cost model, when metering
+	metering bool    // are we metering?
+	usage    []usage // what each word cost in this run
+	spent    int     // the total cost of this run
 
-	Source *bufio.Reader // our input
-	Sink   *bufio.Writer // out output
+	recoverPanics bool // turn panics in words into errors?
+	threaded      bool // run composite words in threaded form?
+	noPeephole    bool // leave compiled code as it is, for debugging?
+	lateBinding   bool // redefining a word updates its callers?
 
-	marker uint16 // place to roll back to when we FORGET
+	patches []patch // calls redefine pointed at new words, oldest first
 
 	Compiling bool // are we compiling right now?
 }
 
 // Define adds a word to the VM
+// The word goes in the current vocabulary unless it names its own,
+// and without a File, the Go code calling Define is recorded as
+// where it was defined.
 func (vm *VM) Define(name string, word Word) {
-	vm.dict[name] = uint16(len(vm.words))
+	word.name = name
+	if word.Vocab == "" {
+		word.Vocab = vm.vocab
+	}
+	if word.File == "" {
+		if _, file, line, ok := runtime.Caller(1); ok {
+			word.File, word.Line = file, line
+		}
+	}
+	word.prev = -1
+	if prev, ok := vm.dict[name]; ok {
+		word.prev = int(prev)
+	}
+	vm.dict[name] = uint32(len(vm.words))
 	vm.words = append(vm.words, word)
 }
 
-// Forget removes words from the VM up to the
-// vm.marker.
-func forget(vm *VM) error {
-	if len(vm.words) < int(vm.marker) {
-		return ErrBadState
+// wordName finds a name for the word at `idx', for messages
+func (vm *VM) wordName(idx uint32) string {
+	if int(idx) < len(vm.words) && vm.words[idx].name != "" {
+		return vm.words[idx].name
 	}
+	return fmt.Sprintf("<word %d>", idx)
+}
 
-	for k, v := range vm.dict {
-		if v >= vm.marker {
-			delete(vm.dict, k)
+// dispatch runs the word at `idx', metering it and recovering
+// from panics if the VM is set up to.  `inner' tells if it is
+// being run straight from the inner interpreter.
+func (vm *VM) dispatch(idx uint32, inner bool) error {
+	vm.inner = inner
+	if vm.metering {
+		if err := vm.meter(idx); err != nil {
+			return err
 		}
 	}
-	vm.words = vm.words[:vm.marker]
-	return nil
+	if vm.recoverPanics {
+		return vm.safeRun(idx)
+	}
+	return vm.words[idx].Run(vm)
 }
 
-// Mark sets the marker for a future call to Forget
-func mark(vm *VM) error {
-	vm.marker = uint16(len(vm.words))
-	return nil
+// notePos records `pos' as the source of any codeseg cells which
+// don't have a position yet.
+func (vm *VM) notePos(pos Pos) {
+	for len(vm.srcmap) < len(vm.codeseg) {
+		vm.srcmap = append(vm.srcmap, pos)
+	}
+}
+
+// posAt gives the source position of the codeseg cell at `ip'
+func (vm *VM) posAt(ip int) Pos {
+	if ip >= 0 && ip < len(vm.srcmap) {
+		return vm.srcmap[ip]
+	}
+	return Pos{}
 }
 
 // Push a value onto the stack
@@ -80,25 +201,30 @@ func (vm *VM) Push(v interface{}) {
 
 // debugPrint prints the codeseg...
 func debugPrint(vm *VM) error {
-	var revdict = make(map[uint16]string)
+	var revdict = make(map[uint32]string)
 	for k, v := range vm.dict {
 		revdict[v] = k
 	}
 	for i, v := range vm.codeseg {
 		opcode, ok := revdict[v]
 		if !ok {
-			opcode = fmt.Sprintf("%d", int16(v))
+			opcode = fmt.Sprintf("%d", int32(v))
 		}
-		fmt.Printf("%03d: %d (%s)\n", i, v, opcode)
+		fmt.Fprintf(vm.out, "%03d: %d (%s)\n", i, v, opcode)
 	}
 	return nil
 }
 
 // Pop a value from the stack, returning the value there
 func (vm *VM) Pop() (v interface{}, err error) {
+	return vm.pop("")
+}
+
+// pop is Pop, naming `word' in the error on underflow
+func (vm *VM) pop(word string) (v interface{}, err error) {
 	l := len(vm.Stack) - 1
 	if l < 0 {
-		err = ErrUnderflow
+		err = &StackError{Word: word, Need: 1, Have: 0}
 		return
 	}
 	v = vm.Stack[l]
@@ -106,6 +232,44 @@ func (vm *VM) Pop() (v interface{}, err error) {
 	return
 }
 
+// popInt pops an int for `word', complaining if it's something else
+func (vm *VM) popInt(word string) (int, error) {
+	v, err := vm.pop(word)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := v.(int)
+	if !ok {
+		return 0, typeError(word, "int", v)
+	}
+	return i, nil
+}
+
+// popXT pops an execution token for `word', making sure it
+// refers to a real word
+func (vm *VM) popXT(word string) (uint32, error) {
+	xt, err := vm.popInt(word)
+	if err != nil {
+		return 0, err
+	}
+	if xt < 0 || xt >= len(vm.words) || vm.words[xt].Run == nil {
+		return 0, &ArgumentError{Word: word, Reason: fmt.Sprintf("%d is not an execution token", xt)}
+	}
+	return uint32(xt), nil
+}
+
+// underflow gives the error for `word' needing `need' items on the
+// stack when there aren't that many
+func (vm *VM) underflow(word string, need int) error {
+	return &StackError{Word: word, Need: need, Have: len(vm.Stack)}
+}
+
+// rUnderflow gives the error for `word' needing `need' items on the
+// return stack when there aren't that many
+func (vm *VM) rUnderflow(word string, need int) error {
+	return &StackError{Word: word, Need: need, Have: len(vm.Rstack), Return: true}
+}
+
 // RPush pushes a value onto the return stack
 func (vm *VM) RPush(v interface{}) {
 	vm.Rstack = append(vm.Rstack, v)
@@ -113,9 +277,14 @@ func (vm *VM) RPush(v interface{}) {
 
 // RPop pops a value from the return stack, returning the value there
 func (vm *VM) RPop() (v interface{}, err error) {
+	return vm.rpop("")
+}
+
+// rpop is RPop, naming `word' in the error on underflow
+func (vm *VM) rpop(word string) (v interface{}, err error) {
 	l := len(vm.Rstack) - 1
 	if l < 0 {
-		err = ErrRStackUnderflow
+		err = vm.rUnderflow(word, 1)
 		return
 	}
 	v = vm.Rstack[l]
@@ -124,27 +293,61 @@ func (vm *VM) RPop() (v interface{}, err error) {
 }
 
 // CreatePusher generates a word in the dictionary, and returns the
-// index for the word.  No name is associated with the word.
-func (vm *VM) CreatePusher(v interface{}) uint16 {
-	vm.words = append(vm.words, Word{Run: func(fvm *VM) error { fvm.Push(v); return nil }, Immediate: false})
-	return uint16(len(vm.words) - 1)
+// index for the word.  No name is associated with the word.  The
+// compiler doesn't need these, since it puts literals in the pool.
+func (vm *VM) CreatePusher(v interface{}) uint32 {
+	vm.words = append(vm.words, Word{Run: func(fvm *VM) error { fvm.Push(v); return nil }, lit: v, isLit: true})
+	return uint32(len(vm.words) - 1)
+}
+
+// An Option configures a VM as NewVM creates it
+type Option func(*VM)
+
+// WithFS adds a filesystem for words like `include' to load files
+// from.  Once a VM has any filesystems, it can only load from them,
+// so this is also a way to sandbox scripts.  Without any, files come
+// from the host's filesystem.
+func WithFS(fsys fs.FS) Option {
+	return func(vm *VM) {
+		vm.roots = append(vm.roots, fsys)
+	}
+}
+
+// WithSearchPath sets the directories, within each filesystem, where
+// words like `include' look for files which aren't found next to the
+// file doing the including.  The default is just ".".
+func WithSearchPath(dirs ...string) Option {
+	return func(vm *VM) {
+		vm.searchPath = append(vm.searchPath, dirs...)
+	}
 }
 
 // NewVM returns a new Forth VM, initialized with the base
-// wordset
-func NewVM() *VM {
+// wordset and configured by the given options.  Unless an
+// option says otherwise, the core prelude is loaded.
+func NewVM(opts ...Option) *VM {
 	ans := &VM{
-		dict:      make(map[string]uint16),
+		dict:      make(map[string]uint32),
+		loaded:    make(map[string]bool),
 		Compiling: true,
+		Sink:      bufio.NewWriter(os.Stdout),
+		ErrSink:   bufio.NewWriter(os.Stderr),
 	}
+	ans.out = ans.Sink
+	ans.vocab = "kernel"
 
 	// SPECIAL... must be specific opcodes to match constants
-	ans.Define("(RET)", Word{nil, false})
-	ans.Define("(litINT)", Word{litINT, false})
-	ans.Define("(litUINT)", Word{litUINT, false})
-	ans.Define("compile,", Word{compileComma, false})
-	ans.Define("(branch)", Word{branchUnconditional, false})
-	ans.Define("(bzr)", Word{branchZero, false})
+	ans.Define("(RET)", Word{Hidden: true, Effect: "( -- )", Doc: "returns from a composite word"})
+	ans.Define("(litINT)", Word{Hidden: true, Run: litINT, Effect: "( -- n )", Doc: "pushes the signed 32-bit number in the next cell"})
+	ans.Define("(litUINT)", Word{Hidden: true, Run: litUINT, Effect: "( -- n )", Doc: "pushes the unsigned 32-bit number in the next cell"})
+	ans.Define("compile,", Word{Run: compileComma, Effect: "( xt -- )", Doc: "compiles a call to xt into the current definition"})
+	ans.Define("(branch)", Word{Hidden: true, Run: branchUnconditional, Effect: "( -- )", Doc: "jumps by the relative amount in the next cell"})
+	ans.Define("(bzr)", Word{Hidden: true, Run: branchZero, Effect: "( flag -- )", Doc: "jumps by the relative amount in the next cell when flag is zero"})
+	ans.Define("(setupDo)", Word{Hidden: true, Run: setupDo, Effect: "( limit start -- )", Doc: "moves the DO loop parameters to the r-stack"})
+	ans.Define("(testDo)", Word{Hidden: true, Run: testDo, Effect: "( -- )", Doc: "leaves the DO loop when the index reaches the limit"})
+	ans.Define("(perfLoopPlus)", Word{Hidden: true, Run: performLoopPlus, Effect: "( n -- )", Doc: "adds n to the DO loop index"})
+	ans.Define("(tailcall)", Word{Hidden: true, Run: tailCall, Effect: "( -- )", Doc: "calls the word in the next cell in place of the current one"})
+	ans.Define("(litPOOL)", Word{Hidden: true, Run: litPOOL, Effect: "( -- x )", Doc: "pushes the literal in the pool at the index in the next cell"})
 	// END SPECIALS
 
 	branchWordsInit(ans)
@@ -152,21 +355,64 @@ func NewVM() *VM {
 	ioWordsInit(ans)
 	parseWordsInit(ans)
 	numWordsInit(ans)
+	optimizeWordsInit(ans)
+	captureWordsInit(ans)
+	includeWordsInit(ans)
+
+	markerWordsInit(ans)
+	rebindWordsInit(ans)
 
 	// these come from this file...
-	ans.Define("mark", Word{mark, false})
-	ans.Define("forget", Word{forget, false})
-	ans.Define("debug.", Word{debugPrint, false})
+	ans.Define("debug.", Word{Run: debugPrint, Effect: "( -- )", Doc: "prints the raw codeseg"})
+	dictWordsInit(ans)
+
+	ans.prelude = PreludeCore
+	ans.vocab = "user"
+	for _, opt := range opts {
+		opt(ans)
+	}
+	ans.loadPrelude()
+	ans.vocab = "user"
+	ans.marker = ans.markHere()
 	return ans
 }
 
 // Run interprets an input stream 'r', writing output
-// to an output stream 'w'
-func (vm *VM) Run(r io.Reader, w io.Writer) error {
-	vm.Source = bufio.NewReader(r)
+// to an output stream 'w'.  All output is flushed before
+// Run returns.
+func (vm *VM) Run(r io.Reader, w io.Writer) (err error) {
+	vm.src = newSource(r)
 	vm.Sink = bufio.NewWriter(w)
+	vm.out = vm.Sink
+	vm.captures = nil
 	vm.Compiling = true
-	return interpret(vm)
+	vm.curundo = nil
+	vm.startRun()
+	defer func() {
+		if ferr := vm.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	if err = interpret(vm); err == nil {
+		err = vm.unfinished("Run")
+	}
+	return err
+}
+
+// SetErrOutput sets the stream where diagnostics go, which
+// is os.Stderr by default.
+func (vm *VM) SetErrOutput(w io.Writer) {
+	vm.ErrSink = bufio.NewWriter(w)
+}
+
+// Flush writes out anything buffered for the output and
+// error streams.
+func (vm *VM) Flush() error {
+	err := vm.Sink.Flush()
+	if eerr := vm.ErrSink.Flush(); err == nil {
+		err = eerr
+	}
+	return err
 }
 
 // ResetState recovers from an error and puts us in
@@ -177,5 +423,14 @@ func (vm *VM) ResetState() {
 	vm.Compiling = true
 	vm.curdef = 0
 	vm.curname = ""
+	vm.curundo = nil
+	vm.cstack = nil
 	vm.ip = 0
+	vm.calls = nil
+	vm.out = vm.Sink
+	vm.captures = nil
+	if len(vm.sources) > 0 {
+		vm.src = vm.sources[0]
+		vm.sources = nil
+	}
 }
@@ -1,6 +1,12 @@
 package forth
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
 
 var (
 	// ErrBadState reports bad VM states
@@ -14,4 +20,426 @@ var (
 
 	// ErrRStackUnderflow reports when the Rstack is too low
 	ErrRStackUnderflow = errors.New("r-stack underflow")
+
+	// ErrUnknownWord reports a token that is neither a word nor a literal
+	ErrUnknownWord = errors.New("unknown word")
+
+	// ErrStepLimit reports a run which went over its step limit
+	ErrStepLimit = errors.New("too many steps")
+
+	// ErrStackLimit reports a data stack deeper than its limit
+	ErrStackLimit = errors.New("data stack too deep")
+
+	// ErrRStackLimit reports a return stack deeper than its limit
+	ErrRStackLimit = errors.New("r-stack too deep")
+
+	// ErrCodeLimit reports a codeseg bigger than its limit
+	ErrCodeLimit = errors.New("too much code")
+
+	// ErrWordsLimit reports a dictionary bigger than its limit
+	ErrWordsLimit = errors.New("too many words")
+
+	// ErrStringLimit reports a string longer than its limit
+	ErrStringLimit = errors.New("string too long")
+
+	// ErrOverflow reports a codeseg or dictionary too big to address
+	ErrOverflow = errors.New("too big to address")
+
+	// ErrDepthLimit reports calls nested deeper than their limit
+	ErrDepthLimit = errors.New("calls nested too deeply")
+
+	// ErrBudget reports a run which cost more than its budget
+	ErrBudget = errors.New("over budget")
+
+	// ErrPanic reports a word which panicked
+	ErrPanic = errors.New("panic")
+
+	// ErrBadCode reports compiled code which is not safe to run
+	ErrBadCode = errors.New("invalid code")
+
+	// ErrImage reports an image which can't be saved or loaded
+	ErrImage = errors.New("bad image")
 )
+
+// UnknownWordError reports a name which isn't in the dictionary, and
+// can't be read as a literal either. It wraps ErrUnknownWord.
+type UnknownWordError struct {
+	Name string
+}
+
+func (e *UnknownWordError) Error() string {
+	return fmt.Sprintf("unknown word <%s>", e.Name)
+}
+
+// Unwrap gives ErrUnknownWord
+func (e *UnknownWordError) Unwrap() error {
+	return ErrUnknownWord
+}
+
+// TypeError reports a word receiving a value of the wrong type. It
+// wraps ErrArgument.
+type TypeError struct {
+	Word string // the word that was given the value
+	Want string // a description of what the word needed
+	Got  string // the type it got instead
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%s: wanted %s, got %s", e.Word, e.Want, e.Got)
+}
+
+// Unwrap gives ErrArgument
+func (e *TypeError) Unwrap() error {
+	return ErrArgument
+}
+
+// typeError builds a TypeError for `word', which got `v' when it
+// wanted `want'
+func typeError(word, want string, v interface{}) error {
+	got := "nothing"
+	if v != nil {
+		got = fmt.Sprintf("%T", v)
+	}
+	return &TypeError{Word: word, Want: want, Got: got}
+}
+
+// ArgumentError reports a value of the right type, which is still
+// unusable by a word. It wraps ErrArgument.
+type ArgumentError struct {
+	Word   string
+	Reason string
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Word, e.Reason)
+}
+
+// Unwrap gives ErrArgument
+func (e *ArgumentError) Unwrap() error {
+	return ErrArgument
+}
+
+// StackError reports a word which needed more items on a stack than
+// were there.  It wraps ErrUnderflow, or ErrRStackUnderflow when it
+// was the return stack.
+type StackError struct {
+	Word   string // the word that needed the items, if known
+	Need   int    // how many items it needed
+	Have   int    // how many there were
+	Return bool   // was it the return stack?
+}
+
+func (e *StackError) Error() string {
+	var sb strings.Builder
+	if e.Word != "" {
+		sb.WriteString(e.Word)
+		sb.WriteString(": ")
+	}
+	sb.WriteString(e.Unwrap().Error())
+	fmt.Fprintf(&sb, " (need %d, have %d)", e.Need, e.Have)
+	return sb.String()
+}
+
+// Unwrap gives ErrUnderflow or ErrRStackUnderflow
+func (e *StackError) Unwrap() error {
+	if e.Return {
+		return ErrRStackUnderflow
+	}
+	return ErrUnderflow
+}
+
+// StateError reports a word used when the VM isn't in a state to
+// run it, like `;' when not compiling, or a broken control structure.
+// It wraps ErrBadState.
+type StateError struct {
+	Word   string
+	Reason string
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Word, e.Reason)
+}
+
+// Unwrap gives ErrBadState
+func (e *StateError) Unwrap() error {
+	return ErrBadState
+}
+
+// StructureError reports control structures which don't match up,
+// like a THEN without an IF, or a definition which ends with a DO
+// still waiting for its LOOP.  It wraps ErrBadState.
+type StructureError struct {
+	Word string   // the word which found the problem
+	Want []string // what it needed to close, if anything
+	Open string   // the structure left open, if there is one
+	Pos  Pos      // where the open structure started
+}
+
+func (e *StructureError) Error() string {
+	switch {
+	case e.Open == "":
+		return fmt.Sprintf("%s: no matching %s", e.Word, strings.Join(e.Want, " or "))
+	case len(e.Want) == 0:
+		return fmt.Sprintf("%s: the %s at %v is never finished", e.Word, e.Open, e.Pos)
+	}
+	return fmt.Sprintf("%s: no matching %s, but the %s at %v is still open", e.Word, strings.Join(e.Want, " or "), e.Open, e.Pos)
+}
+
+// Unwrap gives ErrBadState
+func (e *StructureError) Unwrap() error {
+	return ErrBadState
+}
+
+// ImageError reports a problem saving or loading an image.  It
+// wraps ErrImage.
+type ImageError struct {
+	Word   string // the word with the problem, if it was one word
+	Reason string
+}
+
+func (e *ImageError) Error() string {
+	if e.Word != "" {
+		return fmt.Sprintf("image: <%s>: %s", e.Word, e.Reason)
+	}
+	return "image: " + e.Reason
+}
+
+// Unwrap gives ErrImage
+func (e *ImageError) Unwrap() error {
+	return ErrImage
+}
+
+// LimitError reports code going past one of the VM's Limits, or past
+// what the VM can address at all.  It wraps the error for the limit,
+// like ErrStepLimit.
+type LimitError struct {
+	Err error // which limit it was
+	Max int   // the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v (limit %d)", e.Err, e.Max)
+}
+
+// Unwrap gives which limit it was
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError reports a word which panicked, when the VM recovers
+// from panics.  It wraps ErrPanic.
+type PanicError struct {
+	Word  string      // the word that panicked
+	Value interface{} // what it panicked with
+	Stack []byte      // the Go stack where it panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: panic: %v", e.Word, e.Value)
+}
+
+// Unwrap gives ErrPanic
+func (e *PanicError) Unwrap() error {
+	return ErrPanic
+}
+
+// VerifyError reports a composite word whose code failed
+// verification.  It wraps ErrBadCode.
+type VerifyError struct {
+	Word   string
+	Offset int // where the problem is, counting from the start of the word
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s: at %d: %s", e.Word, e.Offset, e.Reason)
+}
+
+// Unwrap gives ErrBadCode
+func (e *VerifyError) Unwrap() error {
+	return ErrBadCode
+}
+
+// Error is what the VM returns when running code fails.  It wraps the
+// underlying error with where it happened: the position in the source,
+// the word that failed, and the composite words that were running.
+type Error struct {
+	Err         error    // the underlying error
+	Word        string   // the word that failed
+	Pos         Pos      // where the failing word came from
+	Trace       []Frame  // the composite words being run, innermost first
+	Suggestions []string // similar names, when the word was unknown
+}
+
+// Frame is one composite word in the call trace of an Error, along
+// with the position in its definition that was running.
+type Frame struct {
+	Word string
+	Pos  Pos
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	if e.Pos.IsValid() {
+		sb.WriteString(e.Pos.String())
+		sb.WriteString(": ")
+	}
+	sb.WriteString(e.message())
+	for _, f := range e.Trace {
+		fmt.Fprintf(&sb, " [in %s]", f.Word)
+	}
+	return sb.String()
+}
+
+// message gives the underlying error's message, prefixed by the word
+// that failed unless the message already names it.
+func (e *Error) message() string {
+	msg := e.Err.Error()
+	if e.Word == "" || strings.HasPrefix(msg, e.Word+": ") {
+		return msg
+	}
+	return e.Word + ": " + msg
+}
+
+// Unwrap gives the underlying error, for errors.Is and errors.As
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FormatError gives a multi-line description of an error, fit for
+// showing to a person.  For errors from the VM, that includes the
+// offending source line with a caret under the failing word, the
+// chain of words that were running, and suggestions for misspelled
+// words.  Other errors just give their message.
+func FormatError(err error) string {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err.Error()
+	}
+
+	var sb strings.Builder
+	if e.Pos.IsValid() {
+		fmt.Fprintf(&sb, "%v: ", e.Pos)
+	}
+	sb.WriteString(e.message())
+	sb.WriteByte('\n')
+
+	if line, ok := e.Pos.text(); ok {
+		sb.WriteString("    ")
+		sb.WriteString(line)
+		sb.WriteString("\n    ")
+		sb.WriteString(caretLine(line, e.Pos.Col, utf8.RuneCountInString(e.Word)))
+		sb.WriteByte('\n')
+	}
+	for _, f := range e.Trace {
+		fmt.Fprintf(&sb, "  in %s at %v\n", f.Word, f.Pos)
+	}
+	if len(e.Suggestions) > 0 {
+		fmt.Fprintf(&sb, "  did you mean: %s?\n", strings.Join(e.Suggestions, ", "))
+	}
+	return sb.String()
+}
+
+// caretLine builds the line of carets to go under `width' runes of
+// `line', starting at column `col'. Tabs in the line are kept so the
+// carets still line up.
+func caretLine(line string, col, width int) string {
+	if width < 1 {
+		width = 1
+	}
+	var sb strings.Builder
+	n := 1
+	for _, ch := range line {
+		if n >= col {
+			break
+		}
+		if ch == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+		n++
+	}
+	sb.WriteString(strings.Repeat("^", width))
+	return sb.String()
+}
+
+// wrapError attaches a position and word name to an error, unless it
+// already has them from deeper in the VM.
+func wrapError(err error, word string, pos Pos) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Err: err, Word: word, Pos: pos}
+}
+
+// suggest finds defined words which are close in spelling to `name'.
+func (vm *VM) suggest(name string) []string {
+	limit := utf8.RuneCountInString(name) / 3
+	if limit < 1 {
+		limit = 1
+	}
+
+	type candidate struct {
+		name string
+		dist int
+	}
+	var found []candidate
+	for k := range vm.dict {
+		if _, ok := vm.findWord(k); !ok {
+			continue
+		}
+		if d := editDistance(name, k); d <= limit {
+			found = append(found, candidate{k, d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].name < found[j].name
+	})
+
+	var ans []string
+	for i := 0; i < len(found) && i < 3; i++ {
+		ans = append(ans, found[i].name)
+	}
+	return ans
+}
+
+// editDistance is the levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// warn writes a warning about the code at `pos' to the error output
+func (vm *VM) warn(pos Pos, format string, args ...interface{}) {
+	fmt.Fprintf(vm.ErrSink, "%v: warning: %s\n", pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,140 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// tstError runs code which should fail, and gives back the *Error
+func tstError(t *testing.T, code string) *Error {
+	t.Helper()
+	vm.ResetState()
+	err := vm.Run(strings.NewReader(code), ioutil.Discard)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected a *forth.Error, got %v", err)
+	}
+	return e
+}
+
+func TestErrorPosition(t *testing.T) {
+	e := tstError(t, "1 2 +\n  3 foo")
+	if e.Word != "foo" || e.Pos.Line != 2 || e.Pos.Col != 5 {
+		t.Errorf("wrong location for error: %v", e)
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	// inlined words don't show up in traces
+	fvm := NewVM(WithoutPeephole())
+	err := fvm.Run(strings.NewReader(": inner drop drop ;\n: outer 1 inner ;\nouter"), ioutil.Discard)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected a *forth.Error, got %v", err)
+	}
+	if !errors.Is(e, ErrUnderflow) || e.Word != "drop" {
+		t.Errorf("wrong error: %v", e)
+	}
+	if e.Pos.Line != 1 || e.Pos.Col != 14 {
+		t.Errorf("wrong position: %v", e.Pos)
+	}
+	if len(e.Trace) != 2 || e.Trace[0].Word != "inner" || e.Trace[1].Word != "outer" {
+		t.Fatalf("wrong trace: %v", e.Trace)
+	}
+	if e.Trace[1].Pos.Line != 2 || e.Trace[1].Pos.Col != 11 {
+		t.Errorf("wrong call site: %v", e.Trace[1].Pos)
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	e := tstError(t, ": sq dup * ;\n2 sq\tdupe")
+	if len(e.Suggestions) == 0 || e.Suggestions[0] != "dup" {
+		t.Errorf("expected suggestion of dup, got %v", e.Suggestions)
+	}
+	msg := FormatError(e)
+	if !strings.Contains(msg, "2 sq\tdupe\n        \t^^^^\n") {
+		t.Errorf("bad caret diagnostic:\n%s", msg)
+	}
+	if !strings.Contains(msg, "did you mean: dup") {
+		t.Errorf("no suggestion in diagnostic:\n%s", msg)
+	}
+}
+
+func TestSourceKeepsFewLines(t *testing.T) {
+	s := newSource(strings.NewReader(strings.Repeat("1 2 +\n", 1000) + "last"))
+	for !s.eof {
+		s.ReadRune()
+	}
+	if len(s.lines) > keptLines {
+		t.Errorf("kept %d lines", len(s.lines))
+	}
+	if _, ok := s.text(1); ok {
+		t.Error("still have the first line")
+	}
+	if text, ok := s.text(1000); !ok || text != "1 2 +" {
+		t.Errorf("wrong recent line: %q", text)
+	}
+	if text, ok := s.text(1001); !ok || text != "last" {
+		t.Errorf("wrong current line: %q", text)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	var uw *UnknownWordError
+	if e := tstError(t, "1 frobnicate"); !errors.As(e, &uw) || uw.Name != "frobnicate" || !errors.Is(e, ErrUnknownWord) {
+		t.Errorf("expected unknown word, got %v", e)
+	}
+
+	var te *TypeError
+	if e := tstError(t, `" a" chr`); !errors.As(e, &te) || te.Word != "chr" || te.Want != "int" || te.Got != "string" {
+		t.Errorf("expected type error, got %v", e)
+	}
+	if e := tstError(t, `" a" 2.5 +`); !errors.Is(e, ErrArgument) {
+		t.Errorf("expected ErrArgument, got %v", e)
+	}
+
+	var se *StackError
+	if e := tstError(t, "1 rot"); !errors.As(e, &se) || se.Word != "rot" || se.Need != 3 || se.Have != 1 {
+		t.Errorf("expected stack error, got %v", e)
+	}
+	if e := tstError(t, "r>"); !errors.Is(e, ErrRStackUnderflow) {
+		t.Errorf("expected r-stack underflow, got %v", e)
+	}
+
+	var st *StateError
+	if e := tstError(t, "1 ;"); !errors.As(e, &st) || st.Word != ";" || !errors.Is(e, ErrBadState) {
+		t.Errorf("expected state error, got %v", e)
+	}
+}
+
+func TestStructureErrors(t *testing.T) {
+	for _, c := range []struct {
+		code, word, open string
+		col              int
+	}{
+		{": a 1 if 2 ;", ";", "if", 7},
+		{": b then ;", "then", "", 0},
+		{": c 0 do 1 if loop ;", "loop", "if", 12},
+		{": d begin 1 repeat ;", "repeat", "", 0},
+		{": e 1 if else else then ;", "else", "else", 10},
+		{": f begin 0 do until ;", "until", "do", 13},
+	} {
+		e := tstError(t, c.code)
+		var se *StructureError
+		if !errors.As(e, &se) || !errors.Is(e, ErrBadState) || se.Word != c.word || se.Open != c.open {
+			t.Errorf("%s: wrong error %v", c.code, e)
+			continue
+		}
+		if se.Open != "" && se.Pos.Col != c.col {
+			t.Errorf("%s: wrong position for the %s: %v", c.code, se.Open, se.Pos)
+		}
+		if e.Word != c.word {
+			t.Errorf("%s: error reported at %s", c.code, e.Word)
+		}
+	}
+
+	// values left by [ ] don't get in the way
+	tstRunForth(t, ": g [ 5 ] if 1 else 2 then [ 6 ] ; 0 g", 5, 6, 2)
+}
@@ -0,0 +1,463 @@
+package forth
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// imageMagic starts every image, so we can tell them from other gobs
+const imageMagic = "go-forth image"
+
+// imageVersion changes whenever the image format does.  LoadImage
+// only reads images of the current version.
+const imageVersion = 1
+
+// the kinds of word in an image
+const (
+	imageHost      = iota // written in Go, re-bound by name
+	imageComposite        // written in forth, with code in the codeseg
+	imageLiteral          // a literal pusher
+	imageDropped          // left out of the image
+	imageMarker           // made by `marker'
+)
+
+// imageHeader comes first in an image, so the version can be
+// checked before anything else is decoded.
+type imageHeader struct {
+	Magic   string
+	Version int
+}
+
+// image is everything saved about a VM
+type image struct {
+	Words   []imageWord
+	Dict    map[string]uint32
+	Codeseg []uint32
+	Srcmap  []imagePos
+	Pool    []interface{}
+	Patches []imagePatch
+	Marker  imageMark
+}
+
+// imageWord is a word as it is saved in an image
+type imageWord struct {
+	Kind      int
+	Name      string
+	Immediate bool
+	Doc       string
+	Effect    string
+	File      string
+	Line      int
+	Vocab     string
+	Cost      int
+
+	CompileOnly, InterpretOnly, Hidden, Deprecated bool
+
+	Prev       int         // the word of the same name it shadowed, or -1
+	Start, End int         // where the code is, for composite words
+	Source     []imageInsn // the code before it was optimized, for composite words
+	Lit        interface{} // the value, for literal pushers
+	Mark       imageMark   // what to roll back to, for markers
+}
+
+// imageMark is a markState, with the size of the codeseg as it is
+// in the image
+type imageMark struct {
+	Words, Code, Pool, Patches int
+	Vocab                      string
+	Loaded                     []string
+}
+
+// imagePatch is a change redefine made, as it is saved in an image.
+// A caller which was compiled again has its old code saved with it.
+type imagePatch struct {
+	Caller, Old uint32
+	Off         int
+	Moved       bool
+	Start, End  int
+	Source      []imageInsn
+}
+
+// imageInsn is an insn of the source of a composite word, as it is
+// saved in an image
+type imageInsn struct {
+	Op, Arg uint32
+	Target  int
+	Src     imagePos
+}
+
+// imagePos is a Pos without its source, which can't be saved
+type imagePos struct {
+	Source    string
+	Line, Col int
+}
+
+// ImageOption changes what SaveImage writes
+type ImageOption int
+
+const (
+	// DropUnreferenced leaves out the definitions which can't be
+	// reached from the dictionary, like words which have been
+	// redefined and aren't used by anything else.
+	DropUnreferenced ImageOption = iota
+)
+
+// SaveImage writes the compiled state of the VM, its dictionary
+// and the code of every word written in forth, so LoadImage can
+// recreate it without reading the source again.  Words written in
+// Go are saved by name only.  The stacks and any open files are
+// not part of the image.
+func (vm *VM) SaveImage(w io.Writer, opts ...ImageOption) error {
+	keep := func(uint32) bool { return true }
+	for _, opt := range opts {
+		if opt == DropUnreferenced {
+			reached := vm.reachable()
+			keep = func(idx uint32) bool { return reached[idx] }
+		}
+	}
+
+	img := image{
+		Words: make([]imageWord, len(vm.words)),
+		Dict:  make(map[string]uint32, len(vm.dict)),
+		Pool:  vm.pool,
+	}
+	for name, idx := range vm.dict {
+		if keep(idx) {
+			img.Dict[name] = idx
+		}
+	}
+	// the code of a word goes into the image along with any old code
+	// of its which forgetting a redefinition would bring back
+	saveCode := func(cw *CompositeWord) (start, end int) {
+		start = len(img.Codeseg)
+		img.Codeseg = append(img.Codeseg, vm.codeseg[cw.start:cw.end]...)
+		for ip := cw.start; ip < cw.end; ip++ {
+			pos := vm.posAt(ip)
+			img.Srcmap = append(img.Srcmap, imagePos{pos.Source, pos.Line, pos.Col})
+		}
+		return start, len(img.Codeseg)
+	}
+	img.Patches = make([]imagePatch, len(vm.patches))
+	moved := make(map[uint32][]int)
+	for j, p := range vm.patches {
+		img.Patches[j] = imagePatch{Caller: p.caller, Old: p.old, Off: p.off}
+		if p.body != nil {
+			moved[p.caller] = append(moved[p.caller], j)
+		}
+	}
+
+	// the code moves, so the marks have to be worked out again from
+	// where the code of each word starts in the image
+	codeBefore := make([]int, len(vm.words)+1)
+	var markers []int
+	for i, word := range vm.words {
+		iw := imageWord{
+			Name:      word.name,
+			Immediate: word.Immediate,
+			Doc:       word.Doc,
+			Effect:    word.Effect,
+			File:      word.File,
+			Line:      word.Line,
+			Vocab:     word.Vocab,
+			Cost:      word.Cost,
+			Prev:      word.prev,
+
+			CompileOnly:   word.CompileOnly,
+			InterpretOnly: word.InterpretOnly,
+			Hidden:        word.Hidden,
+			Deprecated:    word.Deprecated,
+		}
+		codeBefore[i] = len(img.Codeseg)
+		switch {
+		case !keep(uint32(i)):
+			iw = imageWord{Kind: imageDropped, Name: word.name, Prev: word.prev}
+		case word.mark != nil:
+			iw.Kind = imageMarker
+			markers = append(markers, i)
+		case word.isLit:
+			iw.Kind, iw.Lit = imageLiteral, word.lit
+		case word.body != nil:
+			// the code moves, since dropped words leave gaps
+			iw.Kind = imageComposite
+			iw.Start, iw.End = saveCode(word.body)
+			iw.Source = saveSource(word.body.source)
+			for _, j := range moved[uint32(i)] {
+				ip := &img.Patches[j]
+				ip.Moved, ip.Source = true, saveSource(vm.patches[j].body.source)
+				ip.Start, ip.End = saveCode(vm.patches[j].body)
+			}
+		default:
+			iw.Kind = imageHost
+		}
+		img.Words[i] = iw
+	}
+
+	codeBefore[len(vm.words)] = len(img.Codeseg)
+	saveMark := func(m markState) imageMark {
+		return imageMark{Words: m.words, Code: codeBefore[m.words], Pool: m.pool, Patches: m.patches, Vocab: m.vocab, Loaded: m.loaded}
+	}
+	for _, i := range markers {
+		img.Words[i].Mark = saveMark(*vm.words[i].mark)
+	}
+	img.Marker = saveMark(vm.marker)
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(imageHeader{Magic: imageMagic, Version: imageVersion}); err != nil {
+		return err
+	}
+	if err := enc.Encode(&img); err != nil {
+		return &ImageError{Reason: err.Error()}
+	}
+	return nil
+}
+
+// reachable finds the words which can be reached from the
+// dictionary, directly or through the code of other words.  Any
+// literal which could be an execution token counts as a reference,
+// since it might be given to `execute', and so does a word redefine
+// replaced, since forgetting the new one brings it back.
+func (vm *VM) reachable() map[uint32]bool {
+	reached := make(map[uint32]bool)
+	var todo []uint32
+	visit := func(idx uint32) {
+		if int(idx) < len(vm.words) && !reached[idx] {
+			reached[idx] = true
+			todo = append(todo, idx)
+		}
+	}
+	visitLit := func(v interface{}) {
+		if n, ok := v.(int); ok && n >= 0 && n < len(vm.words) {
+			visit(uint32(n))
+		}
+	}
+
+	for idx := uint32(0); idx <= lastSpecial; idx++ {
+		visit(idx)
+	}
+	for _, idx := range vm.dict {
+		visit(idx)
+	}
+	for _, p := range vm.patches {
+		visit(p.old)
+	}
+	for len(todo) > 0 {
+		word := vm.words[todo[len(todo)-1]]
+		todo = todo[:len(todo)-1]
+		switch {
+		case word.isLit:
+			visitLit(word.lit)
+		case word.body != nil:
+			for _, in := range vm.decode(word.body) {
+				visit(in.op)
+				switch in.op {
+				case opTailCall:
+					visit(in.arg)
+				case opLitINT:
+					visitLit(int(int32(in.arg)))
+				case opLitUINT:
+					visitLit(int(in.arg))
+				case opLitPOOL:
+					visitLit(vm.pool[in.arg])
+				}
+			}
+		}
+	}
+	return reached
+}
+
+// LoadImage makes a VM from an image written by SaveImage.  The VM
+// is configured by `opts', as with NewVM, except that no prelude is
+// loaded, since the image has whatever words it needs.  Words
+// written in Go are found by name among the VM's own words, so a
+// host which adds words of its own should add them with an Option.
+// The code in the image is verified, and LoadImage gives a
+// *VerifyError rather than a VM which could crash running it.
+func LoadImage(r io.Reader, opts ...Option) (*VM, error) {
+	dec := gob.NewDecoder(r)
+	var hdr imageHeader
+	if err := dec.Decode(&hdr); err != nil || hdr.Magic != imageMagic {
+		return nil, &ImageError{Reason: "not a forth image"}
+	}
+	if hdr.Version != imageVersion {
+		return nil, &ImageError{Reason: fmt.Sprintf("version %d, but only version %d can be loaded", hdr.Version, imageVersion)}
+	}
+	var img image
+	if err := dec.Decode(&img); err != nil {
+		return nil, &ImageError{Reason: err.Error()}
+	}
+
+	opts = append(opts[:len(opts):len(opts)], WithPrelude(PreludeNone))
+	vm := NewVM(opts...)
+	host := make(map[string]Word, len(vm.words))
+	for _, word := range vm.words {
+		host[word.name] = word
+	}
+	bound := make(map[string]bool)
+	if len(img.Words) <= lastSpecial {
+		return nil, &ImageError{Reason: "the image has no kernel"}
+	}
+	if len(img.Words) > maxCells || len(img.Codeseg) > maxCells {
+		return nil, &ImageError{Reason: "the image is too big to address"}
+	}
+	for op := 0; op <= lastSpecial; op++ {
+		if img.Words[op].Name != vm.words[op].name {
+			return nil, &ImageError{Word: img.Words[op].Name, Reason: fmt.Sprintf("expected <%s> as opcode %d", vm.words[op].name, op)}
+		}
+	}
+
+	words := make([]Word, len(img.Words))
+	for i, iw := range img.Words {
+		var word Word
+		switch iw.Kind {
+		case imageHost:
+			hw, ok := host[iw.Name]
+			if !ok || hw.body != nil || hw.isLit {
+				return nil, &ImageError{Word: iw.Name, Reason: "the host does not define this word"}
+			}
+			word = hw
+			bound[iw.Name] = true
+		case imageComposite:
+			if iw.Start < 0 || iw.Start >= iw.End || iw.End > len(img.Codeseg) {
+				return nil, &ImageError{Word: iw.Name, Reason: "code is outside the codeseg"}
+			}
+			source, err := loadSource(iw.Source, &img)
+			if err != nil {
+				return nil, &ImageError{Word: iw.Name, Reason: err.Error()}
+			}
+			cw := &CompositeWord{start: iw.Start, end: iw.End, name: iw.Name, source: source}
+			word = Word{Run: cw.Run, body: cw}
+		case imageLiteral:
+			v := iw.Lit
+			word = Word{Run: func(fvm *VM) error { fvm.Push(v); return nil }, lit: v, isLit: true}
+		case imageMarker:
+			m := loadMark(iw.Mark)
+			word = markerWord(iw.Name, &m)
+		case imageDropped:
+			name := iw.Name
+			word = Word{Run: func(*VM) error {
+				return &StateError{Word: name, Reason: "this word was dropped from the image"}
+			}}
+		default:
+			return nil, &ImageError{Word: iw.Name, Reason: fmt.Sprintf("unknown kind of word %d", iw.Kind)}
+		}
+		if iw.Kind != imageHost {
+			word.name, word.Immediate = iw.Name, iw.Immediate
+			word.CompileOnly, word.InterpretOnly = iw.CompileOnly, iw.InterpretOnly
+			word.Hidden, word.Deprecated = iw.Hidden, iw.Deprecated
+			word.Doc, word.Effect, word.Vocab = iw.Doc, iw.Effect, iw.Vocab
+			word.File, word.Line, word.Cost = iw.File, iw.Line, iw.Cost
+		}
+		if iw.Prev < -1 || iw.Prev >= i {
+			return nil, &ImageError{Word: iw.Name, Reason: fmt.Sprintf("shadows word %d, which isn't older than it", iw.Prev)}
+		}
+		word.prev = iw.Prev
+		words[i] = word
+	}
+
+	dict := make(map[string]uint32, len(img.Dict))
+	for name, idx := range img.Dict {
+		if int(idx) >= len(words) {
+			return nil, &ImageError{Word: name, Reason: fmt.Sprintf("refers to word %d, past the end of the dictionary", idx)}
+		}
+		dict[name] = idx
+	}
+
+	// host words the image doesn't know about, like ones added to
+	// the host since the image was saved, still get defined
+	for i, word := range vm.words {
+		if !bound[word.name] && vm.dict[word.name] == uint32(i) {
+			word.prev = -1
+			if _, shadowed := dict[word.name]; !shadowed {
+				dict[word.name] = uint32(len(words))
+			}
+			words = append(words, word)
+		}
+	}
+
+	// newest first, so each patch is checked against the code its
+	// caller had when it was made
+	patches := make([]patch, len(img.Patches))
+	bodies := make(map[uint32]*CompositeWord)
+	for i := len(img.Patches) - 1; i >= 0; i-- {
+		p := img.Patches[i]
+		if int(p.Caller) >= len(words) || int(p.Old) >= len(words) {
+			return nil, &ImageError{Reason: fmt.Sprintf("patch %d refers past the end of the dictionary", i)}
+		}
+		name := words[p.Caller].name
+		body, ok := bodies[p.Caller]
+		if !ok {
+			body = words[p.Caller].body
+		}
+		patches[i] = patch{caller: p.Caller, off: p.Off, old: p.Old}
+		switch {
+		case p.Moved:
+			if p.Start < 0 || p.Start >= p.End || p.End > len(img.Codeseg) {
+				return nil, &ImageError{Word: name, Reason: fmt.Sprintf("patch %d has code outside the codeseg", i)}
+			}
+			source, err := loadSource(p.Source, &img)
+			if err != nil {
+				return nil, &ImageError{Word: name, Reason: err.Error()}
+			}
+			patches[i].body = &CompositeWord{start: p.Start, end: p.End, name: name, source: source}
+			bodies[p.Caller] = patches[i].body
+		case body != nil && (p.Off < 0 || p.Off >= body.end-body.start):
+			return nil, &ImageError{Word: name, Reason: fmt.Sprintf("patch %d is outside its code", i)}
+		}
+	}
+
+	srcmap := make([]Pos, len(img.Srcmap))
+	for i, p := range img.Srcmap {
+		srcmap[i] = Pos{Source: p.Source, Line: p.Line, Col: p.Col}
+	}
+
+	vm.words, vm.dict = words, dict
+	vm.codeseg, vm.srcmap = img.Codeseg, srcmap
+	vm.pool, vm.marker, vm.patches = img.Pool, loadMark(img.Marker), patches
+	vm.indexPool()
+	if err := vm.Verify(); err != nil {
+		return nil, err
+	}
+	for _, p := range patches {
+		if p.body != nil {
+			if err := vm.verifyWord(p.body); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return vm, nil
+}
+
+// saveSource gives the source of a composite word as it is saved
+func saveSource(source []insn) []imageInsn {
+	var ans []imageInsn
+	for _, in := range source {
+		ans = append(ans, imageInsn{Op: in.op, Arg: in.arg, Target: in.target, Src: imagePos{in.src.Source, in.src.Line, in.src.Col}})
+	}
+	return ans
+}
+
+// loadSource gives back the source of a composite word from the
+// image, checking it can be compiled without going outside the
+// dictionary, the pool or itself
+func loadSource(saved []imageInsn, img *image) ([]insn, error) {
+	var ans []insn
+	for i, in := range saved {
+		switch {
+		case int(in.Op) >= len(img.Words):
+			return nil, fmt.Errorf("source refers to word %d, past the end of the dictionary", in.Op)
+		case isBranch(in.Op) && (in.Target < 0 || in.Target >= len(saved)):
+			return nil, fmt.Errorf("source branches outside itself at %d", i)
+		case in.Op == opLitPOOL && int(in.Arg) >= len(img.Pool):
+			return nil, fmt.Errorf("source refers to entry %d, past the end of the pool", in.Arg)
+		}
+		src := Pos{Source: in.Src.Source, Line: in.Src.Line, Col: in.Src.Col}
+		ans = append(ans, insn{op: in.Op, arg: in.Arg, target: in.Target, src: src})
+	}
+	return ans, nil
+}
+
+// loadMark gives the markState saved as `im'
+func loadMark(im imageMark) markState {
+	return markState{words: im.Words, code: im.Code, pool: im.Pool, patches: im.Patches, vocab: im.Vocab, loaded: im.Loaded}
+}
@@ -0,0 +1,132 @@
+package forth
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tstImage saves `from' to an image and loads it back
+func tstImage(t *testing.T, from *VM, save []ImageOption, load ...Option) (*VM, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := from.SaveImage(&buf, save...); err != nil {
+		t.Fatal(err)
+	}
+	return LoadImage(&buf, load...)
+}
+
+// tstOutputOf runs code on `fvm', giving back what it printed
+func tstOutputOf(t *testing.T, fvm *VM, code string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := fvm.Run(strings.NewReader(code), &out); err != nil {
+		t.Error(err)
+	}
+	return out.String()
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	orig := NewVM()
+	tstOutputOf(t, orig, `: greet ( n -- ) " hi " type 2.5 * . ;
+: twice dup ['] greet execute ['] greet execute ;
+: big 70000 . ; immediate`)
+
+	loaded, err := tstImage(t, orig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tstOutputOf(t, loaded, `2 twice 1 2 nip . big`); out != "hi 5 hi 5 2 70000 " {
+		t.Errorf("wrong output from loaded image: %q", out)
+	}
+	w, ok := loaded.Lookup("greet")
+	if !ok || w.Effect != "( n -- )" || w.Vocab != "user" {
+		t.Errorf("metadata not kept: %+v", w)
+	}
+	if see, _ := loaded.See("greet"); see != `: greet ( n -- ) " hi " type 2.5 * . ;` {
+		t.Errorf("wrong decompiled code: %s", see)
+	}
+}
+
+func TestImageHostWords(t *testing.T) {
+	withAnswer := func(fvm *VM) {
+		fvm.Define("answer", Word{Run: func(fvm *VM) error { fvm.Push(42); return nil }})
+	}
+	orig := NewVM(withAnswer)
+	tstOutputOf(t, orig, `: show answer . ;`)
+
+	loaded, err := tstImage(t, orig, nil, withAnswer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tstOutputOf(t, loaded, `show`); out != "42 " {
+		t.Errorf("wrong output: %q", out)
+	}
+
+	var ie *ImageError
+	if _, err = tstImage(t, orig, nil); !errors.As(err, &ie) || ie.Word != "answer" || !errors.Is(err, ErrImage) {
+		t.Errorf("expected missing host word, got %v", err)
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(imageHeader{Magic: imageMagic, Version: imageVersion + 1})
+	if _, err := LoadImage(&buf); !errors.Is(err, ErrImage) {
+		t.Errorf("expected a version error, got %v", err)
+	}
+	if _, err := LoadImage(strings.NewReader("garbage")); !errors.Is(err, ErrImage) {
+		t.Errorf("expected a bad image error, got %v", err)
+	}
+}
+
+func TestImageBadPrev(t *testing.T) {
+	fvm := NewVM()
+	tstOutputOf(t, fvm, ": dup 1 ;")
+	var buf bytes.Buffer
+	if err := fvm.SaveImage(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dec := gob.NewDecoder(&buf)
+	var hdr imageHeader
+	var img image
+	if err := dec.Decode(&hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&img); err != nil {
+		t.Fatal(err)
+	}
+
+	// a word can only shadow one older than itself
+	last := len(img.Words) - 1
+	for _, prev := range []int{-2, last, last + 1, 1 << 30} {
+		img.Words[last].Prev = prev
+		buf.Reset()
+		enc := gob.NewEncoder(&buf)
+		enc.Encode(hdr)
+		enc.Encode(&img)
+		var ie *ImageError
+		if _, err := LoadImage(&buf); !errors.As(err, &ie) || ie.Word != "dup" {
+			t.Errorf("prev %d: expected an image error, got %v", prev, err)
+		}
+	}
+}
+
+func TestImageDropUnreferenced(t *testing.T) {
+	orig := NewVM(WithPrelude(PreludeNone))
+	tstOutputOf(t, orig, `: a 1 ; : b a ; : a 2 ;
+: c 1 2 3 4 5 6 7 8 9 ; : c 3 ;`)
+
+	loaded, err := tstImage(t, orig, []ImageOption{DropUnreferenced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.codeseg) >= len(orig.codeseg) {
+		t.Errorf("nothing was dropped: %d cells, was %d", len(loaded.codeseg), len(orig.codeseg))
+	}
+	if out := tstOutputOf(t, loaded, `b . a . c .`); out != "1 2 3 " {
+		t.Errorf("wrong output: %q", out)
+	}
+}
@@ -0,0 +1,293 @@
+package forth
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// maxSourceDepth is how deeply include and evaluate can nest
+const maxSourceDepth = 64
+
+// runSource reads and handles everything in `s', in whatever state
+// the VM is in, then goes back to the previous source.  The state is
+// restored along with the source, whether `s' ran out or failed.
+func (vm *VM) runSource(word string, s *source) (err error) {
+	if len(vm.sources) >= maxSourceDepth {
+		return &StateError{Word: word, Reason: "sources are nested too deeply"}
+	}
+	from := vm.tok
+	compiling := vm.Compiling
+	vm.sources = append(vm.sources, vm.src)
+	vm.src = s
+	defer func() {
+		l := len(vm.sources) - 1
+		vm.src = vm.sources[l]
+		vm.sources = vm.sources[:l]
+		vm.Compiling = compiling
+		if e, ok := err.(*Error); ok {
+			e.Trace = append(e.Trace, Frame{Word: word, Pos: from})
+		}
+	}()
+
+	for (err == nil) && !s.eof {
+		if vm.Compiling {
+			err = compileTokens(vm)
+		} else {
+			vm.Compiling = true
+			err = interpret(vm)
+		}
+	}
+	if err == nil {
+		err = vm.unfinished(word)
+	}
+	return
+}
+
+// osFS is the filesystem of the host, which file-loading words use
+// when the VM wasn't given any filesystems of its own.  Unlike
+// os.DirFS, it takes absolute paths and paths relative to the working
+// directory.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(filepath.FromSlash(name))
+}
+
+// module is a file of forth code, found in one of the VM's filesystems
+type module struct {
+	fsys fs.FS
+	path string
+}
+
+// loadKey gives the name to remember a loaded module by
+func (vm *VM) loadKey(m module) string {
+	if _, ok := m.fsys.(osFS); ok {
+		if abs, err := filepath.Abs(filepath.FromSlash(m.path)); err == nil {
+			return abs
+		}
+	}
+	return fmt.Sprintf("%d:%s", vm.fsID(m.fsys), m.path)
+}
+
+// fsID gives a number for a filesystem, the same every time it is
+// asked about the same one.
+func (vm *VM) fsID(fsys fs.FS) int {
+	for i, known := range vm.filesystems {
+		if sameFS(known, fsys) {
+			return i
+		}
+	}
+	vm.filesystems = append(vm.filesystems, fsys)
+	return len(vm.filesystems) - 1
+}
+
+// sameFS reports whether two filesystems are the same one. Some, like
+// fstest.MapFS, can't be compared with ==.
+func sameFS(a, b fs.FS) bool {
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	if ta.Comparable() {
+		return a == b
+	}
+	switch ta.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	return false
+}
+
+// exists reports whether the module is a file that can be loaded
+func (m module) exists() bool {
+	fi, err := fs.Stat(m.fsys, m.path)
+	return err == nil && !fi.IsDir()
+}
+
+// resolve finds the module called `name'.  Relative names are looked
+// for next to the file being loaded, and then in each directory of
+// the search path, in each of the VM's filesystems.  Without any
+// filesystems configured, the host filesystem is used.
+func (vm *VM) resolve(word, name string) (module, error) {
+	roots := vm.roots
+	if len(roots) == 0 {
+		roots = []fs.FS{osFS{}}
+		if filepath.IsAbs(name) {
+			return module{osFS{}, filepath.ToSlash(name)}, nil
+		}
+	}
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+
+	var candidates []module
+	if vm.src != nil && vm.src.file != nil {
+		from := vm.src.file
+		candidates = append(candidates, module{from.fsys, path.Join(path.Dir(from.path), name)})
+	}
+	dirs := vm.searchPath
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	for _, r := range roots {
+		for _, d := range dirs {
+			candidates = append(candidates, module{r, path.Join(d, name)})
+		}
+	}
+
+	for _, m := range candidates {
+		if _, ok := m.fsys.(osFS); !ok && !fs.ValidPath(m.path) {
+			continue
+		}
+		if m.exists() {
+			return m, nil
+		}
+	}
+	return module{}, &fs.PathError{Op: word, Path: name, Err: fs.ErrNotExist}
+}
+
+// includeModule runs the module as forth code, and notes that it has
+// been loaded for `require'.  It counts as loaded while it runs, so
+// modules which require each other don't loop, but not if it fails,
+// so it can be required again once the problem is fixed.
+func (vm *VM) includeModule(word string, m module) error {
+	f, err := m.fsys.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	key := vm.loadKey(m)
+	vm.loaded[key] = true
+	s := newNamedSource(f, m.path)
+	s.file = &m
+	if err = vm.runSource(word, s); err != nil {
+		delete(vm.loaded, key)
+	}
+	return err
+}
+
+// includeFile finds the file called `name', and runs it
+func (vm *VM) includeFile(word, name string) error {
+	m, err := vm.resolve(word, name)
+	if err != nil {
+		return err
+	}
+	return vm.includeModule(word, m)
+}
+
+// requireFile is includeFile, unless the file has already been loaded
+func (vm *VM) requireFile(word, name string) error {
+	m, err := vm.resolve(word, name)
+	if err != nil {
+		return err
+	}
+	if vm.loaded[vm.loadKey(m)] {
+		return nil
+	}
+	return vm.includeModule(word, m)
+}
+
+// LoadModule runs the forth code in the file `name' from `fsys',
+// unless it has already been loaded.  Modules it includes are
+// looked for next to it first, and then through the VM's search path.
+// The code is interpreted, so it is a way for a host to preload
+// definitions before running scripts.
+func (vm *VM) LoadModule(fsys fs.FS, name string) (err error) {
+	compiling := vm.Compiling
+	vm.Compiling = false
+	vm.curundo = nil
+	vm.startRun()
+	defer func() {
+		vm.Compiling = compiling
+		if ferr := vm.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	m := module{fsys, name}
+	if vm.loaded[vm.loadKey(m)] {
+		return nil
+	}
+	return vm.includeModule("LoadModule", m)
+}
+
+// fileName reads a file name from the input, for `word'.  Unlike other
+// tokens, it keeps its case.
+func fileName(vm *VM, word string) (string, error) {
+	buf, err := rawToken(vm, nil)
+	if err != nil {
+		return "", &StateError{Word: word, Reason: "no file name given"}
+	}
+	return string(buf), nil
+}
+
+// popString pops a string for `word', complaining if it's something else
+func (vm *VM) popString(word string) (string, error) {
+	v, err := vm.pop(word)
+	if err != nil {
+		return "", err
+	}
+	str, ok := v.(string)
+	if !ok {
+		return "", typeError(word, "string", v)
+	}
+	return str, nil
+}
+
+// include <file> runs the named file
+func include(vm *VM) error {
+	path, err := fileName(vm, "include")
+	if err != nil {
+		return err
+	}
+	return vm.includeFile("include", path)
+}
+
+// included ( str -- ) runs the file named on the stack
+func included(vm *VM) error {
+	path, err := vm.popString("included")
+	if err != nil {
+		return err
+	}
+	return vm.includeFile("included", path)
+}
+
+// require <file> runs the named file, unless it has already run
+func require(vm *VM) error {
+	path, err := fileName(vm, "require")
+	if err != nil {
+		return err
+	}
+	return vm.requireFile("require", path)
+}
+
+// required ( str -- ) runs the file named on the stack, unless it
+// has already run
+func required(vm *VM) error {
+	path, err := vm.popString("required")
+	if err != nil {
+		return err
+	}
+	return vm.requireFile("required", path)
+}
+
+// evaluate ( str -- ) runs the string as forth code
+func evaluate(vm *VM) error {
+	code, err := vm.popString("evaluate")
+	if err != nil {
+		return err
+	}
+	return vm.runSource("evaluate", newNamedSource(strings.NewReader(code), "<evaluate>"))
+}
+
+// includeWordsInit adds the words for loading code to the VM
+func includeWordsInit(vm *VM) {
+	vm.Define("include", Word{Run: include, Effect: "( \"file\" -- )", Doc: "runs a file of forth code"})
+	vm.Define("included", Word{Run: included, Effect: "( str -- )", Doc: "runs the file of forth code named by str"})
+	vm.Define("require", Word{Run: require, Effect: "( \"file\" -- )", Doc: "runs a file of forth code, unless it has already been loaded"})
+	vm.Define("required", Word{Run: required, Effect: "( str -- )", Doc: "runs the file named by str, unless it has already been loaded"})
+	vm.Define("evaluate", Word{Run: evaluate, Effect: "( str -- )", Doc: "runs str as forth code"})
+}
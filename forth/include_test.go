@@ -0,0 +1,116 @@
+package forth
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// tstFile writes a file of forth code for a test, giving its path
+func tstFile(t *testing.T, dir, name, code string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEvaluate(t *testing.T) {
+	tstRunForth(t, `" 1 2 +" evaluate 10`, 3, 10)
+	tstRunForth(t, `" : sq dup * ;" evaluate 5 sq`, 25)
+	tstRunForth(t, `: run evaluate 1 + ; " 2 3 *" run`, 7)
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	lib := tstFile(t, dir, "Lib.fs", ": triple 3 * ;\n7\n")
+	tstRunForth(t, "include "+lib+" triple", 21)
+	tstRunForth(t, `" `+lib+`" included`, 7)
+
+	// require only loads the file once
+	other := tstFile(t, dir, "other.fs", "42\n")
+	tstRunForth(t, "require "+other+" require "+other+` " `+other+`" required`, 42)
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := tstFile(t, dir, "bad.fs", "1 2\n+ drop drop\n")
+	e := tstError(t, "include "+bad+" 20")
+	if !errors.Is(e, ErrUnderflow) || e.Pos.Source != bad || e.Pos.Line != 2 || e.Pos.Col != 8 {
+		t.Errorf("wrong error: %v", e)
+	}
+	if len(e.Trace) != 1 || e.Trace[0].Word != "include" || e.Trace[0].Pos.Line != 1 {
+		t.Errorf("wrong trace: %v", e.Trace)
+	}
+
+	// the original source is back in place after the error
+	vm.ResetState()
+	if vm.src.name == bad || len(vm.sources) != 0 {
+		t.Errorf("source was not restored")
+	}
+}
+
+func TestModules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/main.fs":   {Data: []byte("require helper.fs\n: main helper ;\nrequire lib.fs\n")},
+		"app/helper.fs": {Data: []byte(": helper 1 ;\n")},
+		"lib/lib.fs":    {Data: []byte("require helper.fs\n: libword 2 ;\n")},
+		"lib/helper.fs": {Data: []byte(": helper 100 ;\n")},
+	}
+	mvm := NewVM(WithFS(fsys), WithSearchPath("lib"))
+	if err := mvm.LoadModule(fsys, "app/main.fs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mvm.Run(strings.NewReader("main libword require helper.fs helper"), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// main found its own helper, lib.fs came from the search path,
+	// and lib.fs found its neighbor helper
+	want := []interface{}{1, 2, 100}
+	if len(mvm.Stack) != len(want) {
+		t.Fatalf("wrong stack: %v", mvm.Stack)
+	}
+	for i := range want {
+		if mvm.Stack[i] != want[i] {
+			t.Fatalf("wrong stack: %v", mvm.Stack)
+		}
+	}
+}
+
+func TestRequireAfterFailure(t *testing.T) {
+	fsys := fstest.MapFS{"lib.fs": {Data: []byte("1 +\n: twice 2 * ;\n")}}
+	fvm := NewVM()
+	if err := fvm.LoadModule(fsys, "lib.fs"); !errors.Is(err, ErrUnderflow) {
+		t.Fatalf("expected an underflow, got %v", err)
+	}
+
+	// a module which failed isn't counted as loaded, so it can be
+	// tried again
+	fvm.ResetState()
+	fsys["lib.fs"] = &fstest.MapFile{Data: []byte(": twice 2 * ;\n")}
+	if err := fvm.LoadModule(fsys, "lib.fs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fvm.Run(strings.NewReader("21 twice"), ioutil.Discard); err != nil || len(fvm.Stack) != 1 || fvm.Stack[0] != 42 {
+		t.Errorf("module not loaded again: %v %v", err, fvm.Stack)
+	}
+}
+
+func TestModuleSandbox(t *testing.T) {
+	host := tstFile(t, t.TempDir(), "host.fs", "1\n")
+	mvm := NewVM(WithFS(fstest.MapFS{}))
+	err := mvm.Run(strings.NewReader("include "+host), ioutil.Discard)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing file, got %v", err)
+	}
+	err = mvm.Run(strings.NewReader("include ../../etc/passwd"), ioutil.Discard)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing file, got %v", err)
+	}
+}
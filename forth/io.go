@@ -9,7 +9,7 @@ import (
 )
 
 // eatWhitespace eats whitespace and returns the next non-ws char
-func eatWhitespace(r *bufio.Reader) (rune, error) {
+func eatWhitespace(r io.RuneReader) (rune, error) {
 	var (
 		ch  rune
 		err error
@@ -28,7 +28,7 @@ func eatWhitespace(r *bufio.Reader) (rune, error) {
 // delimitedRead reads from the `source` until the delimiter (a rune)
 // is found.  It will use the provided `buf` to
 // avoid allocation, if one is provided.
-func delimitedRead(source *bufio.Reader, delim rune, buf []rune) ([]rune, error) {
+func delimitedRead(source io.RuneReader, delim rune, buf []rune) ([]rune, error) {
 	var (
 		ch  rune
 		err error
@@ -51,7 +51,7 @@ func delimitedRead(source *bufio.Reader, delim rune, buf []rune) ([]rune, error)
 // delimitedWSRead reads from the `source` until whitespace
 // is found.  It will use the provided `buf` to
 // avoid allocation, if one is provided.
-func delimitedWSRead(source *bufio.Reader, buf []rune) ([]rune, error) {
+func delimitedWSRead(source io.RuneReader, buf []rune) ([]rune, error) {
 	var (
 		ch  rune
 		err error
@@ -83,7 +83,7 @@ func read(vm *VM) error {
 		err   error
 	)
 
-	delimStack, err := vm.Pop()
+	delimStack, err := vm.pop("read")
 	if err != nil {
 		return err
 	}
@@ -96,18 +96,18 @@ func read(vm *VM) error {
 		delim, sz = utf8.DecodeRuneInString(delimT)
 		// it needs to be a one-char string
 		if sz != len(delimT) {
-			return ErrArgument
+			return &ArgumentError{Word: "read", Reason: "the delimiter must be a single character"}
 		}
 	default:
-		return ErrArgument
+		return typeError("read", "an int or a one-character string", delimStack)
 	}
 
 	buf := make([]rune, 0, 20)
 
 	if delim == ' ' {
-		buf, err = delimitedWSRead(vm.Source, buf)
+		buf, err = delimitedWSRead(vm.src, buf)
 	} else {
-		buf, err = delimitedRead(vm.Source, delim, buf)
+		buf, err = delimitedRead(vm.src, delim, buf)
 	}
 	vm.Push(string(buf))
 	return err
@@ -119,13 +119,13 @@ func skip(vm *VM) error {
 	if err != nil {
 		return err
 	}
-	_, err = vm.Pop()
+	_, err = vm.pop("skip")
 	return err
 }
 
 // : " 34 read (compiling?) if postpone literal then ; immediate
 func openQuote(vm *VM) error {
-	buf, err := delimitedRead(vm.Source, '"', nil)
+	buf, err := delimitedRead(vm.src, '"', nil)
 	if err != nil {
 		return err
 	}
@@ -141,14 +141,10 @@ func openQuote(vm *VM) error {
 // chrFromInt ('chr') takes an integer and makes a one-char string of it, interpreted
 // as a rune
 func chrFromInt(vm *VM) error {
-	value, err := vm.Pop()
+	chInt, err := vm.popInt("chr")
 	if err != nil {
 		return err
 	}
-	chInt, ok := value.(int)
-	if !ok {
-		return ErrArgument
-	}
 	vm.Push(string([]rune{rune(chInt)}))
 	return nil
 }
@@ -156,18 +152,18 @@ func chrFromInt(vm *VM) error {
 // ordFromStr ('ord') takes a one-character string and gives its rune
 // value as an int. It is the inverse of 'chr'.
 func ordFromStr(vm *VM) error {
-	value, err := vm.Pop()
+	value, err := vm.pop("ord")
 	if err != nil {
 		return err
 	}
 	chStr, ok := value.(string)
 	if !ok {
-		return ErrArgument
+		return typeError("ord", "string", value)
 	}
 	r, sz := utf8.DecodeRuneInString(chStr)
 	// it needs to be a one-char string
 	if sz != len(chStr) {
-		return ErrArgument
+		return &ArgumentError{Word: "ord", Reason: "needs a one-character string"}
 	}
 	vm.Push(int(r))
 
@@ -179,7 +175,7 @@ func ordFromStr(vm *VM) error {
 func printStack(vm *VM) error {
 	tot := len(vm.Stack)
 	for i, v := range vm.Stack {
-		fmt.Printf("%2d: %v\n", tot-i, v)
+		fmt.Fprintf(vm.out, "%2d: %v\n", tot-i, v)
 	}
 	return nil
 }
@@ -187,9 +183,9 @@ func printStack(vm *VM) error {
 // printTop prints out the top element on the stack, removing
 // it in the process. It puts a trailing space after the item.
 func printTop(vm *VM) error {
-	v, err := vm.Pop()
+	v, err := vm.pop(".")
 	if err == nil {
-		fmt.Print(v, " ")
+		fmt.Fprint(vm.out, v, " ")
 	}
 	return err
 }
@@ -197,28 +193,63 @@ func printTop(vm *VM) error {
 // printOut ('type') prints out the top element on the stack, removing
 // it in the process. It does not include a trailing space.
 func printStr(vm *VM) error {
-	v, err := vm.Pop()
+	v, err := vm.pop("type")
 	if err == nil {
-		fmt.Print(v)
+		fmt.Fprint(vm.out, v)
 	}
 	return err
 }
 
 // cr simply prints a carriage return
 func printCR(vm *VM) error {
-	fmt.Println()
+	_, err := fmt.Fprintln(vm.out)
+	return err
+}
+
+// redirect sends the output of the printing words to `w',
+// flushing what was already written so the two streams stay
+// in order.
+func (vm *VM) redirect(w io.Writer) error {
+	if w == vm.out {
+		return nil
+	}
+	if f, ok := vm.out.(*bufio.Writer); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	vm.out = w
 	return nil
 }
 
+// toStderr ('>stderr') sends further output to the error stream
+func toStderr(vm *VM) error {
+	return vm.redirect(vm.ErrSink)
+}
+
+// toStdout ('>stdout') sends further output back to the normal
+// output stream
+func toStdout(vm *VM) error {
+	return vm.redirect(vm.Sink)
+}
+
+// flush writes out any buffered output
+func flush(vm *VM) error {
+	return vm.Flush()
+}
+
 // ioWordsInit adds the io-related core words to the VM.
 func ioWordsInit(vm *VM) {
-	vm.Define("read", Word{read, false})
-	vm.Define("skip", Word{skip, false})
-	vm.Define("\"", Word{openQuote, true})
-	vm.Define("chr", Word{chrFromInt, false})
-	vm.Define("ord", Word{ordFromStr, false})
-	vm.Define(".s", Word{printStack, false})
-	vm.Define(".", Word{printTop, false})
-	vm.Define("type", Word{printStr, false})
-	vm.Define("cr", Word{printCR, false})
+	vm.Define("read", Word{Run: read, Effect: "( delim -- str )", Doc: "reads input up to the delimiter character"})
+	vm.Define("skip", Word{Run: skip, Effect: "( delim -- )", Doc: "skips input up to the delimiter character"})
+	vm.Define("\"", Word{Run: openQuote, Immediate: true, Effect: "( \"text<quote>\" -- str )", Doc: "reads a string up to the next double quote"})
+	vm.Define("chr", Word{Run: chrFromInt, Effect: "( n -- str )", Doc: "makes a one-character string from a code point"})
+	vm.Define("ord", Word{Run: ordFromStr, Effect: "( str -- n )", Doc: "gives the code point of a one-character string"})
+	vm.Define(".s", Word{Run: printStack, Effect: "( -- )", Doc: "prints the stack, without changing it"})
+	vm.Define(".", Word{Run: printTop, Effect: "( x -- )", Doc: "prints x, followed by a space"})
+	vm.Define("type", Word{Run: printStr, Effect: "( x -- )", Doc: "prints x"})
+	vm.Define("cr", Word{Run: printCR, Effect: "( -- )", Doc: "prints a newline"})
+	vm.Define(">stderr", Word{Run: toStderr, Effect: "( -- )", Doc: "sends further output to the error stream"})
+	vm.Define(">stdout", Word{Run: toStdout, Effect: "( -- )", Doc: "sends further output back to the normal output stream"})
+	vm.Define("flush", Word{Run: flush, Effect: "( -- )", Doc: "writes out any buffered output"})
 }
@@ -0,0 +1,42 @@
+package forth
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tstOutput runs code on the test vm, giving back what it wrote
+// to the output and error streams.
+func tstOutput(t *testing.T, code string) (string, string) {
+	t.Helper()
+	var out, errs bytes.Buffer
+	vm.ResetState()
+	vm.SetErrOutput(&errs)
+	defer vm.SetErrOutput(os.Stderr)
+	if err := vm.Run(strings.NewReader(code), &out); err != nil {
+		t.Error(err)
+	}
+	return out.String(), errs.String()
+}
+
+func TestOutput(t *testing.T) {
+	out, errs := tstOutput(t, `1 . " hi" type cr 2 3 .s`)
+	if out != "1 hi\n 2: 2\n 1: 3\n" || errs != "" {
+		t.Errorf("wrong output: %q %q", out, errs)
+	}
+}
+
+func TestStderr(t *testing.T) {
+	out, errs := tstOutput(t, `1 . >stderr " oops" type >stdout 2 .`)
+	if out != "1 2 " || errs != "oops" {
+		t.Errorf("wrong output: %q %q", out, errs)
+	}
+
+	// a new run always starts on the normal output
+	out, errs = tstOutput(t, `>stderr 1 .`)
+	if out != "" || errs != "1 " {
+		t.Errorf("wrong output: %q %q", out, errs)
+	}
+}
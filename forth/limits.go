@@ -0,0 +1,166 @@
+package forth
+
+import (
+	"context"
+	"io"
+	"math"
+	"strings"
+)
+
+// Limits caps what the code a VM runs can do, so it can run scripts
+// which aren't trusted.  A zero field means there is no limit.
+type Limits struct {
+	Steps  int // words run, per call to Run or LoadModule
+	Stack  int // depth of the data stack
+	RStack int // depth of the return stack
+	Code   int // cells in the codeseg, in total
+	Words  int // words in the dictionary, in total, counting the kernel
+	String int // bytes in a string, builder or output capture
+	Depth  int // nested calls of words written in forth; zero for 10000
+}
+
+// defaultMaxDepth is how deeply calls can nest, without a limit
+const defaultMaxDepth = 10000
+
+// maxDepth gives how deeply calls can nest
+func (l *Limits) maxDepth() int {
+	if l.Depth > 0 {
+		return l.Depth
+	}
+	return defaultMaxDepth
+}
+
+// maxCells is the most cells the codeseg, and words the dictionary,
+// can hold, so that every index and relative branch fits in a cell.
+// It's a variable so the tests can make it small.
+var maxCells = math.MaxInt32
+
+// overflowed checks that the codeseg and dictionary can still be
+// addressed.  When they can't, whatever didn't fit is dropped, and
+// the error says so.
+func (vm *VM) overflowed() error {
+	if len(vm.codeseg) <= maxCells && len(vm.words) <= maxCells {
+		return nil
+	}
+	if len(vm.codeseg) > maxCells {
+		vm.codeseg = vm.codeseg[:maxCells]
+		if len(vm.srcmap) > maxCells {
+			vm.srcmap = vm.srcmap[:maxCells]
+		}
+	}
+	if len(vm.words) > maxCells {
+		for name, idx := range vm.dict {
+			if int(idx) >= maxCells {
+				delete(vm.dict, name)
+			}
+		}
+		vm.words = vm.words[:maxCells]
+	}
+	return &LimitError{Err: ErrOverflow, Max: maxCells}
+}
+
+// WithLimits sets the limits for a VM.  They don't apply to the
+// prelude NewVM loads.
+func WithLimits(l Limits) Option {
+	return func(vm *VM) {
+		vm.SetLimits(l)
+	}
+}
+
+// SetLimits changes the limits for a VM
+func (vm *VM) SetLimits(l Limits) {
+	vm.limits = l
+	vm.limited = l != Limits{Depth: l.Depth}
+	vm.lastCode, vm.lastWords = len(vm.codeseg), len(vm.words)
+}
+
+// RunContext is Run, but stops with the context's error when `ctx'
+// is done.  The VM can be reset and run again afterwards.
+func (vm *VM) RunContext(ctx context.Context, r io.Reader, w io.Writer) error {
+	vm.ctx = ctx
+	defer func() { vm.ctx = nil }()
+	return vm.Run(r, w)
+}
+
+// startRun resets the counts the limits and metering keep for a run
+func (vm *VM) startRun() {
+	vm.steps = 0
+	vm.spent = 0
+	vm.usage = vm.usage[:0]
+}
+
+// step counts a step for the limits, and checks that it didn't take
+// the VM past any of them, or that the run wasn't cancelled.  It is
+// called after each word is run or compiled.
+func (vm *VM) step() error {
+	if vm.ctx != nil {
+		select {
+		case <-vm.ctx.Done():
+			return vm.ctx.Err()
+		default:
+		}
+	}
+
+	if err := vm.overflowed(); err != nil {
+		return err
+	}
+	if !vm.limited {
+		return nil
+	}
+
+	// the code and dictionary only fail as they grow, so a VM at
+	// its limit can still run code which doesn't add to them
+	l := &vm.limits
+	grewCode, grewWords := len(vm.codeseg) > vm.lastCode, len(vm.words) > vm.lastWords
+	vm.lastCode, vm.lastWords = len(vm.codeseg), len(vm.words)
+	vm.steps++
+	switch {
+	case l.Steps > 0 && vm.steps > l.Steps:
+		return &LimitError{Err: ErrStepLimit, Max: l.Steps}
+	case l.Stack > 0 && len(vm.Stack) > l.Stack:
+		return &LimitError{Err: ErrStackLimit, Max: l.Stack}
+	case l.RStack > 0 && len(vm.Rstack) > l.RStack:
+		return &LimitError{Err: ErrRStackLimit, Max: l.RStack}
+	case l.Code > 0 && grewCode && len(vm.codeseg) > l.Code:
+		return &LimitError{Err: ErrCodeLimit, Max: l.Code}
+	case l.Words > 0 && grewWords && len(vm.words) > l.Words:
+		return &LimitError{Err: ErrWordsLimit, Max: l.Words}
+	case l.String > 0 && (vm.longestNew() > l.String || vm.refusedOutput()):
+		return &LimitError{Err: ErrStringLimit, Max: l.String}
+	}
+	return nil
+}
+
+// longestNew gives the length of the value on top of the stack, if
+// it's a string or builder.  A word making a string leaves it on top,
+// so that's the only place a new one needs to be looked for.
+func (vm *VM) longestNew() int {
+	if l := len(vm.Stack); l > 0 {
+		switch v := vm.Stack[l-1].(type) {
+		case string:
+			return len(v)
+		case *strings.Builder:
+			return v.Len()
+		}
+	}
+	return 0
+}
+
+// maxLen is the longest string Go can build
+const maxLen = int(^uint(0) >> 1)
+
+// stringFits checks, before it's built, that a string of `count'
+// pieces, each `size' bytes long, would be within the string limit,
+// and isn't too long for Go to build at all.
+func (vm *VM) stringFits(word string, size, count int) error {
+	if count <= 0 {
+		return nil
+	}
+	if l := vm.limits.String; l > 0 && size > l/count {
+		return &LimitError{Err: ErrStringLimit, Max: l}
+	}
+	if size > maxLen/count {
+		return &ArgumentError{Word: word, Reason: "the string would be too long"}
+	}
+	return nil
+}
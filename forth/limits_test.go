@@ -0,0 +1,137 @@
+package forth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	fvm := NewVM()
+	base := Limits{Steps: 100000, Stack: 100, RStack: 100, String: 1000,
+		Code: len(fvm.codeseg) + 100, Words: len(fvm.words) + 10}
+	for _, c := range []struct {
+		code string
+		want error
+	}{
+		{": f recur ; f", ErrStepLimit},
+		{": f 1 recur ; f", ErrStackLimit},
+		{": f 1 >r recur ; f", ErrRStackLimit},
+		{": f " + strings.Repeat("dup drop ", 60) + ";", ErrCodeLimit},
+		{": a ; : b ; : c ; : d ; : e ; : f ; : g ; : h ; : i ; : j ; : k ;", ErrWordsLimit},
+		{`: f " ab" begin dup + again ; f`, ErrStringLimit},
+		{`: f builder begin " ab" b+ again ; f`, ErrStringLimit},
+		{`: f <capture begin 1 . again ; f`, ErrStringLimit},
+		{`" x" 1000000000000 *`, ErrStringLimit},
+		{`1000000000000 " x" *`, ErrStringLimit},
+		{`" x" 1000 * " x" +`, ErrStringLimit},
+		{`builder " x" 1000 * b+ " x" b+`, ErrStringLimit},
+		{`<capture " x" 1000 * type " x" type`, ErrStringLimit},
+	} {
+		// each time, the VM gets a fresh copy of its dictionary
+		fvm = NewVM(WithLimits(base))
+		err := fvm.Run(strings.NewReader(c.code), ioutil.Discard)
+		var le *LimitError
+		if !errors.Is(err, c.want) || !errors.As(err, &le) {
+			t.Errorf("%s: expected %v, got %v", c.code, c.want, err)
+			continue
+		}
+
+		// the VM still works afterwards
+		fvm.ResetState()
+		if err := fvm.Run(strings.NewReader("1 2 +"), ioutil.Discard); err != nil || len(fvm.Stack) != 1 || fvm.Stack[0] != 3 {
+			t.Errorf("%s: VM not usable after the limit: %v %v", c.code, err, fvm.Stack)
+		}
+	}
+}
+
+func TestStringRepeat(t *testing.T) {
+	// without a limit, strings Go can't build are still refused
+	for _, code := range []string{`" x" -1 *`, `-1 " x" *`, `" xx" 9223372036854775807 *`} {
+		fvm := NewVM()
+		err := fvm.Run(strings.NewReader(code), ioutil.Discard)
+		var ae *ArgumentError
+		if !errors.As(err, &ae) || ae.Word != "*" {
+			t.Errorf("%s: expected an argument error, got %v", code, err)
+		}
+	}
+	if out := tstOutputOf(t, NewVM(), `" ab" 3 * . 2 " c" * . " d" 0 * .`); out != "ababab cc  " {
+		t.Errorf("wrong output: %q", out)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	fvm := NewVM()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := fvm.RunContext(ctx, strings.NewReader(": spin begin again ; spin"), ioutil.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	fvm.ResetState()
+	if err := fvm.Run(strings.NewReader("1 2 +"), ioutil.Discard); err != nil {
+		t.Errorf("VM not usable after being cancelled: %v", err)
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	// deep recursion doesn't use up the Go stack
+	fvm := NewVM(WithLimits(Limits{Depth: 200000}))
+	code := ": down dup if 1 - over execute then ; ' down 150000 down"
+	if err := fvm.Run(strings.NewReader(code), ioutil.Discard); err != nil || len(fvm.Stack) != 2 || fvm.Stack[1] != 0 {
+		t.Errorf("deep recursion failed: %v %v", err, fvm.Stack)
+	}
+
+	// but it is limited
+	fvm = NewVM()
+	err := fvm.Run(strings.NewReader(": ping dup execute 1 ; ' ping ping"), ioutil.Discard)
+	var le *LimitError
+	if !errors.Is(err, ErrDepthLimit) || !errors.As(err, &le) || le.Max != defaultMaxDepth {
+		t.Fatalf("expected the call depth limit, got %v", err)
+	}
+	if e := err.(*Error); len(e.Trace) != defaultMaxDepth || e.Trace[0].Word != "ping" {
+		t.Errorf("wrong trace: %d frames", len(e.Trace))
+	}
+
+	// words written in Go can still call into forth
+	fvm.ResetState()
+	err = fvm.Run(strings.NewReader(`: sq dup * ; : run ['] sq execute 1 + ; 3 run " 2 run" evaluate`), ioutil.Discard)
+	if err != nil || len(fvm.Stack) != 2 || fvm.Stack[0] != 10 || fvm.Stack[1] != 5 {
+		t.Errorf("calls through execute failed: %v %v", err, fvm.Stack)
+	}
+}
+
+func TestWideCode(t *testing.T) {
+	// branches can go further than 32K cells, and big numbers don't
+	// need a word of their own
+	fvm := NewVM()
+	words := len(fvm.words)
+	code := ": far 0 if " + strings.Repeat("1 drop ", 20000) + "then 100000 -100000 4000000000 ; far"
+	if err := fvm.Run(strings.NewReader(code), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fvm.Stack) != "[100000 -100000 4000000000]" || len(fvm.words) != words+1 {
+		t.Errorf("wrong result: %v, with %d new words", fvm.Stack, len(fvm.words)-words)
+	}
+	if see, _ := fvm.See("far"); !strings.HasSuffix(see, "then 100000 -100000 4000000000 ;") {
+		t.Errorf("wrong decompiled code: ...%s", see[len(see)-40:])
+	}
+
+	// when the code does get too big, that's an error
+	defer func(n int) { maxCells = n }(maxCells)
+	maxCells = len(fvm.codeseg) + 10
+	err := fvm.Run(strings.NewReader(": big "+strings.Repeat("dup drop ", 10)+";"), ioutil.Discard)
+	var le *LimitError
+	if !errors.Is(err, ErrOverflow) || !errors.As(err, &le) || le.Max != maxCells {
+		t.Fatalf("expected an overflow, got %v", err)
+	}
+	fvm.ResetState()
+	if err := fvm.Run(strings.NewReader("1 2 +"), ioutil.Discard); err != nil || len(fvm.codeseg) > maxCells {
+		t.Errorf("VM not usable after the overflow: %v", err)
+	}
+}
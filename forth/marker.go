@@ -0,0 +1,110 @@
+package forth
+
+import (
+	"fmt"
+	"sort"
+)
+
+// markState is everything rolling the VM back to a marker puts
+// back: how big the dictionary, codeseg and literal pool were, how
+// many calls redefine had patched, which vocabulary new words went
+// into, and which files had been loaded.
+type markState struct {
+	words, code, pool int
+	patches           int
+	vocab             string
+	loaded            []string
+}
+
+// markHere gives the state of the VM as it is now
+func (vm *VM) markHere() markState {
+	m := markState{words: len(vm.words), code: len(vm.codeseg), pool: len(vm.pool), patches: len(vm.patches), vocab: vm.vocab}
+	for key := range vm.loaded {
+		m.loaded = append(m.loaded, key)
+	}
+	sort.Strings(m.loaded)
+	return m
+}
+
+// rollback puts the VM back to the state `m', for `word'.  Every
+// word defined since is forgotten, and a name they redefined gets
+// its earlier meaning back.  Nothing changes unless all of it can.
+func (vm *VM) rollback(word string, m markState) error {
+	if m.words > len(vm.words) || m.code > len(vm.codeseg) || m.pool > len(vm.pool) || m.patches > len(vm.patches) {
+		return &StateError{Word: word, Reason: "the marker is past the end of the dictionary"}
+	}
+	for _, f := range vm.calls {
+		if f.word.start >= m.code {
+			return &StateError{Word: word, Reason: fmt.Sprintf("%s is running, so it can't be forgotten", f.word.name)}
+		}
+	}
+
+	vm.unpatch(m.patches)
+
+	// newest first, so a name redefined more than once gets back the
+	// meaning it had at the marker
+	for i := len(vm.words) - 1; i >= m.words; i-- {
+		w := vm.words[i]
+		if idx, ok := vm.dict[w.name]; !ok || int(idx) != i {
+			continue
+		}
+		if w.prev >= 0 && w.prev < i {
+			vm.dict[w.name] = uint32(w.prev)
+		} else {
+			delete(vm.dict, w.name)
+		}
+	}
+	vm.words = vm.words[:m.words]
+	vm.codeseg = vm.codeseg[:m.code]
+	if len(vm.srcmap) > m.code {
+		vm.srcmap = vm.srcmap[:m.code]
+	}
+	vm.truncatePool(m.pool)
+	vm.vocab = m.vocab
+	vm.loaded = make(map[string]bool, len(m.loaded))
+	for _, key := range m.loaded {
+		vm.loaded[key] = true
+	}
+	return nil
+}
+
+// mark ( -- ) sets the marker for a future call to forget
+func mark(vm *VM) error {
+	vm.marker = vm.markHere()
+	return nil
+}
+
+// forget ( -- ) rolls the VM back to the last mark
+func forget(vm *VM) error {
+	return vm.rollback("forget", vm.marker)
+}
+
+// marker ( "name" -- ) defines a word which, when it runs, rolls the
+// VM back to how it was before the word was defined
+func marker(vm *VM) error {
+	name, err := nextToken(vm, nil)
+	if err != nil {
+		return &StateError{Word: "marker", Reason: "no name given"}
+	}
+	m := vm.markHere()
+	w := markerWord(name, &m)
+	w.File, w.Line = vm.tok.Source, vm.tok.Line
+	vm.Define(name, w)
+	return nil
+}
+
+// markerWord makes the word for a marker called `name'
+func markerWord(name string, m *markState) Word {
+	return Word{
+		Run:    func(vm *VM) error { return vm.rollback(name, *m) },
+		Effect: "( -- )",
+		Doc:    "forgets every word since, and itself",
+		mark:   m,
+	}
+}
+
+func markerWordsInit(vm *VM) {
+	vm.Define("mark", Word{Run: mark, Effect: "( -- )", Doc: "sets the point FORGET rolls the dictionary back to"})
+	vm.Define("forget", Word{Run: forget, Effect: "( -- )", Doc: "removes the words defined since MARK"})
+	vm.Define("marker", Word{Run: marker, InterpretOnly: true, Effect: "( \"name\" -- )", Doc: "defines a word which forgets every word since, and itself"})
+}
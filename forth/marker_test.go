@@ -0,0 +1,130 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestForgetRestores(t *testing.T) {
+	fvm := NewVM()
+	tstOutputOf(t, fvm, `: a 1 ; mark : a 2 ; : a 3 ; : b a ; forget`)
+	if out := tstOutputOf(t, fvm, `a .`); out != "1 " {
+		t.Errorf("earlier definition not restored: %q", out)
+	}
+	if _, ok := fvm.Lookup("b"); ok {
+		t.Error("b was not forgotten")
+	}
+
+	// forget doesn't go past the prelude without a mark
+	fvm = NewVM()
+	tstOutputOf(t, fvm, `: c 1 ; forget`)
+	if _, ok := fvm.Lookup("nip"); !ok {
+		t.Error("forget removed the prelude")
+	}
+}
+
+func TestMarker(t *testing.T) {
+	fvm := NewVM()
+	words, code, pool := len(fvm.words), len(fvm.codeseg), len(fvm.pool)
+	tstOutputOf(t, fvm, `: x 1 ; marker m1 : x " two" ; : y 3 ;
+marker m2 : y 4.5 ; vocab other : z 5 ;`)
+
+	// a marker was defined where its name was read
+	if m2, _ := fvm.Lookup("m2"); m2.File != "<input>" || m2.Line != 2 {
+		t.Errorf("m2 defined at %s:%d", m2.File, m2.Line)
+	}
+
+	tstOutputOf(t, fvm, `m2`)
+	if out := tstOutputOf(t, fvm, `x . y .`); out != "two 3 " {
+		t.Errorf("wrong words after m2: %q", out)
+	}
+	for _, name := range []string{"z", "m2"} {
+		if _, ok := fvm.Lookup(name); ok {
+			t.Errorf("%s was not forgotten", name)
+		}
+	}
+	tstOutputOf(t, fvm, `: w ;`)
+	if w, _ := fvm.Lookup("w"); w.Vocab != "user" {
+		t.Errorf("vocabulary not rolled back: %s", w.Vocab)
+	}
+
+	// an image keeps the markers
+	loaded, err := tstImage(t, fvm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []*VM{fvm, loaded} {
+		tstOutputOf(t, v, `m1`)
+		if out := tstOutputOf(t, v, `x .`); out != "1 " {
+			t.Errorf("wrong words after m1: %q", out)
+		}
+		if len(v.words) != words+1 || len(v.pool) != pool {
+			t.Errorf("not rolled back: %d words, %d literals", len(v.words)-words, len(v.pool)-pool)
+		}
+	}
+	if len(fvm.codeseg) != code+3 {
+		t.Errorf("codeseg not rolled back: %d cells", len(fvm.codeseg)-code)
+	}
+}
+
+func TestMarkerErrors(t *testing.T) {
+	fvm := NewVM()
+	err := fvm.Run(strings.NewReader(`marker m : f m ; f`), ioutil.Discard)
+	var se *StateError
+	if !errors.As(err, &se) || se.Word != "m" {
+		t.Errorf("expected a StateError from m, got %v", err)
+	}
+	if _, ok := fvm.Lookup("f"); !ok {
+		t.Error("f was forgotten after all")
+	}
+
+	// files loaded since the marker can be loaded again
+	fvm = NewVM(WithFS(fstest.MapFS{"a.fs": {Data: []byte(`" loaded " type`)}}))
+	if out := tstOutputOf(t, fvm, `marker m require a.fs require a.fs m require a.fs`); out != "loaded loaded " {
+		t.Errorf("wrong output: %q", out)
+	}
+}
+
+func TestAtomicDefinitions(t *testing.T) {
+	fvm := NewVM()
+	tstOutputOf(t, fvm, `: a 1 ;`)
+	words, code, pool := len(fvm.words), len(fvm.codeseg), len(fvm.pool)
+	for _, def := range []string{
+		`7 : a 2 if " str" 2.5 nope ;`,
+		`7 : b then ;`,
+		`7 : c begin 1 [ drop drop drop ] ;`,
+		`7 : d 1 if [ 1 0 / ] then ;`,
+		`7 : e 1 2`,
+		`7 : f 1 if " str"`,
+		`7 " : g 1 2" evaluate`,
+	} {
+		fvm.ResetState()
+		if err := fvm.Run(strings.NewReader(def), ioutil.Discard); err == nil {
+			t.Errorf("%s: expected an error", def)
+		}
+		if len(fvm.words) != words || len(fvm.codeseg) != code || len(fvm.pool) != pool {
+			t.Errorf("%s: left %d words, %d cells and %d literals", def,
+				len(fvm.words)-words, len(fvm.codeseg)-code, len(fvm.pool)-pool)
+		}
+		if len(fvm.Stack) != 1 || fvm.Stack[0] != 7 || fvm.Compiling {
+			t.Errorf("%s: left the stack as %v, compiling %v", def, fvm.Stack, fvm.Compiling)
+		}
+		if out := tstOutputOf(t, fvm, `a .`); out != "1 " {
+			t.Errorf("%s: a is now %q", def, out)
+		}
+	}
+
+	// a definition has to end in the source it started in
+	fvm.ResetState()
+	err := fvm.Run(strings.NewReader(`" : g 1 2" evaluate`), ioutil.Discard)
+	var se *StructureError
+	if !errors.As(err, &se) || se.Word != "evaluate" || se.Open != ":" || se.Pos.Col != 1 {
+		t.Errorf("expected an unfinished definition, got %v", err)
+	}
+	if out := tstOutputOf(t, fvm, `: h [ " 3" evaluate ] literal ; h .`); out != "3 " {
+		t.Errorf("evaluate in a definition: %q", out)
+	}
+}
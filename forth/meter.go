@@ -0,0 +1,129 @@
+package forth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Costs is the cost model for metering what a VM runs.  Every word
+// run adds its cost to the total: its own Cost, if it has one, or
+// else the default for its kind here.  Zero defaults count as 1.
+type Costs struct {
+	Builtin int // words of the kernel, and literals
+	Host    int // other words written in Go, like the host's own
+	Call    int // calling a word written in forth, besides its code
+	Budget  int // the most a run can cost; zero for no budget
+}
+
+// WordCost is what one word cost during a run
+type WordCost struct {
+	Name  string
+	Calls int // how many times it was run
+	Cost  int // the cost of those runs, not counting words it called
+}
+
+// CostReport breaks down what a run cost
+type CostReport struct {
+	Total int
+	Words []WordCost // the most expensive first
+}
+
+// usage is what one word cost during a run, indexed by its xt
+type usage struct {
+	calls, cost int
+}
+
+// WithCosts turns on metering, with the given cost model
+func WithCosts(c Costs) Option {
+	return func(vm *VM) {
+		vm.SetCosts(c)
+	}
+}
+
+// SetCosts turns on metering, with the given cost model
+func (vm *VM) SetCosts(c Costs) {
+	vm.costs = c
+	vm.metering = true
+}
+
+// SetCost sets what running the word called `name' costs
+func (vm *VM) SetCost(name string, cost int) error {
+	xt, ok := vm.dict[name]
+	if !ok {
+		return &UnknownWordError{Name: name}
+	}
+	vm.words[xt].Cost = cost
+	return nil
+}
+
+// defaultCost gives the cost of a word which has none of its own
+func (c *Costs) defaultCost(w *Word) int {
+	var cost int
+	switch {
+	case w.body != nil:
+		cost = c.Call
+	case w.isLit || w.Vocab == "kernel":
+		cost = c.Builtin
+	default:
+		cost = c.Host
+	}
+	if cost == 0 {
+		cost = 1
+	}
+	return cost
+}
+
+// meter charges for running the word at `idx', failing when that
+// goes over the budget
+func (vm *VM) meter(idx uint32) error {
+	w := &vm.words[idx]
+	cost := w.Cost
+	if cost == 0 {
+		cost = vm.costs.defaultCost(w)
+	}
+	for int(idx) >= len(vm.usage) {
+		vm.usage = append(vm.usage, usage{})
+	}
+	vm.usage[idx].calls++
+	vm.usage[idx].cost += cost
+	vm.spent += cost
+	if vm.costs.Budget > 0 && vm.spent > vm.costs.Budget {
+		return &LimitError{Err: ErrBudget, Max: vm.costs.Budget}
+	}
+	return nil
+}
+
+// CostReport gives what the last run cost, in total and word by
+// word.  It is empty unless metering is on.
+func (vm *VM) CostReport() CostReport {
+	ans := CostReport{Total: vm.spent}
+	for idx, u := range vm.usage {
+		if u.calls == 0 {
+			continue
+		}
+		name := vm.wordName(uint32(idx))
+		if idx < len(vm.words) && vm.words[idx].isLit {
+			name = literalText(vm.words[idx].lit)
+		}
+		ans.Words = append(ans.Words, WordCost{Name: name, Calls: u.calls, Cost: u.cost})
+	}
+	sort.Slice(ans.Words, func(i, j int) bool {
+		if ans.Words[i].Cost != ans.Words[j].Cost {
+			return ans.Words[i].Cost > ans.Words[j].Cost
+		}
+		return ans.Words[i].Name < ans.Words[j].Name
+	})
+	return ans
+}
+
+// String gives the report as a table
+func (r CostReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-20s %8s %10s\n", "word", "calls", "cost")
+	for _, w := range r.Words {
+		fmt.Fprintf(&sb, "%-20s %8d %10d\n", w.Name, w.Calls, w.Cost)
+	}
+	fmt.Fprintf(&sb, "%-20s %8s %10d\n", "total", "", r.Total)
+	return sb.String()
+}
@@ -0,0 +1,72 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMetering(t *testing.T) {
+	fvm := NewVM(WithCosts(Costs{Builtin: 1, Host: 10, Call: 2}), func(fvm *VM) {
+		fvm.Define("fetch", Word{Run: func(fvm *VM) error { fvm.Push(1); return nil }})
+	})
+	if err := fvm.SetCost("dup", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := fvm.Run(strings.NewReader(": sq dup * ; 3 sq sq fetch"), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	r := fvm.CostReport()
+	want := []WordCost{{"dup", 2, 10}, {"fetch", 1, 10}, {"sq", 2, 4}, {"*", 2, 2}}
+	if r.Total != 28 || len(r.Words) < len(want) {
+		t.Fatalf("wrong report:\n%v", r)
+	}
+	for i, w := range want {
+		if r.Words[i] != w {
+			t.Errorf("expected %v, got %v", w, r.Words[i])
+		}
+	}
+
+	// each run is metered separately
+	if err := fvm.Run(strings.NewReader("1 drop"), ioutil.Discard); err != nil || fvm.CostReport().Total != 1 {
+		t.Errorf("wrong cost for a second run: %v %v", err, fvm.CostReport())
+	}
+
+	// the optimizer doesn't fold, fuse or inline away a costed word
+	fvm = NewVM(WithCosts(Costs{}))
+	for _, name := range []string{"+", "swap", "over"} {
+		if err := fvm.SetCost(name, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fvm.Run(strings.NewReader(": k 2 3 + ; : n 1 2 swap drop ; : d 1 2 over over ; : c k ; c n d"), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"k": ": k 2 3 + ;",
+		"n": ": n 1 2 swap drop ;",
+		"d": ": d 1 2 over over ;",
+		"c": ": c k ;",
+	} {
+		if see, _ := fvm.See(name); see != want {
+			t.Errorf("see %s:\n got %s\nwant %s", name, see, want)
+		}
+	}
+	if total := fvm.CostReport().Total; total < 400 {
+		t.Errorf("costed words not charged: total %d", total)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	fvm := NewVM(WithCosts(Costs{Budget: 1000}))
+	err := fvm.Run(strings.NewReader(": f 1 drop recur ; f"), ioutil.Discard)
+	var le *LimitError
+	if !errors.Is(err, ErrBudget) || !errors.As(err, &le) || le.Max != 1000 {
+		t.Errorf("expected to go over budget, got %v", err)
+	}
+	if r := fvm.CostReport(); r.Total != 1001 {
+		t.Errorf("wrong total: %v", r.Total)
+	}
+}
@@ -1,12 +1,15 @@
 package forth
 
-import "strings"
+import (
+	"reflect"
+	"strings"
+)
 
 // : + ( a b -- a+b ) <code>
 func add(vm *VM) (err error) {
 	top := len(vm.Stack) - 1
 	if top < 1 {
-		return ErrUnderflow
+		return vm.underflow("+", 2)
 	}
 	switch op1 := vm.Stack[top].(type) {
 	case int:
@@ -16,7 +19,7 @@ func add(vm *VM) (err error) {
 		case float64:
 			vm.Stack[top-1] = float64(op1) + op2
 		default:
-			err = ErrArgument
+			err = typeError("+", "numbers or strings", vm.Stack[top-1])
 		}
 	case float64:
 		switch op2 := vm.Stack[top-1].(type) {
@@ -25,17 +28,17 @@ func add(vm *VM) (err error) {
 		case float64:
 			vm.Stack[top-1] = op1 + op2
 		default:
-			err = ErrArgument
+			err = typeError("+", "numbers or strings", vm.Stack[top-1])
 		}
 	case string:
 		op2, ok := vm.Stack[top-1].(string)
-		if ok {
+		if !ok {
+			err = typeError("+", "numbers or strings", vm.Stack[top-1])
+		} else if err = vm.stringFits("+", len(op2)+len(op1), 1); err == nil {
 			vm.Stack[top-1] = op2 + op1
-		} else {
-			err = ErrArgument
 		}
 	default:
-		err = ErrArgument
+		err = typeError("+", "numbers or strings", vm.Stack[top])
 	}
 	vm.Stack = vm.Stack[:top]
 	return
@@ -45,7 +48,7 @@ func add(vm *VM) (err error) {
 func multiply(vm *VM) (err error) {
 	top := len(vm.Stack) - 1
 	if top < 1 {
-		return ErrUnderflow
+		return vm.underflow("*", 2)
 	}
 	switch op1 := vm.Stack[top].(type) {
 	case int:
@@ -55,9 +58,9 @@ func multiply(vm *VM) (err error) {
 		case float64:
 			vm.Stack[top-1] = float64(op1) * op2
 		case string:
-			vm.Stack[top-1] = strings.Repeat(op2, op1)
+			err = vm.repeat(top-1, op2, op1)
 		default:
-			err = ErrArgument
+			err = typeError("*", "numbers, or a string and an int", vm.Stack[top-1])
 		}
 	case float64:
 		switch op2 := vm.Stack[top-1].(type) {
@@ -66,24 +69,233 @@ func multiply(vm *VM) (err error) {
 		case float64:
 			vm.Stack[top-1] = op1 * op2
 		default:
-			err = ErrArgument
+			err = typeError("*", "numbers, or a string and an int", vm.Stack[top-1])
 		}
 	case string:
 		op2, ok := vm.Stack[top-1].(int)
 		if ok {
-			vm.Stack[top-1] = strings.Repeat(op1, op2)
+			err = vm.repeat(top-1, op1, op2)
 		} else {
-			err = ErrArgument
+			err = typeError("*", "numbers, or a string and an int", vm.Stack[top-1])
 		}
 	default:
-		err = ErrArgument
+		err = typeError("*", "numbers, or a string and an int", vm.Stack[top])
 	}
 	vm.Stack = vm.Stack[:top]
 	return
 }
 
+// repeat puts `n' copies of `s' at `at' on the stack, for `*', once
+// it knows the result will fit within the string limit.
+func (vm *VM) repeat(at int, s string, n int) error {
+	if n < 0 {
+		return &ArgumentError{Word: "*", Reason: "can't repeat a string a negative number of times"}
+	}
+	if err := vm.stringFits("*", len(s), n); err != nil {
+		return err
+	}
+	vm.Stack[at] = strings.Repeat(s, n)
+	return nil
+}
+
+// arith applies a binary operation to the top two items on the
+// stack.  Two ints use `iop', and otherwise numbers are converted to
+// floats for `fop'.  When `fop' is nil, only ints are allowed.
+func arith(vm *VM, word string,
+	iop func(a, b int) (interface{}, error),
+	fop func(a, b float64) interface{}) error {
+	top := len(vm.Stack) - 1
+	if top < 1 {
+		return vm.underflow(word, 2)
+	}
+	want := "numbers"
+	if fop == nil {
+		want = "ints"
+	}
+
+	var ans interface{}
+	a, b := vm.Stack[top-1], vm.Stack[top]
+	ia, aIsInt := a.(int)
+	ib, bIsInt := b.(int)
+	if aIsInt && bIsInt {
+		var err error
+		if ans, err = iop(ia, ib); err != nil {
+			return err
+		}
+	} else {
+		fa, ok := toFloat(a)
+		if !ok || fop == nil {
+			return typeError(word, want, a)
+		}
+		fb, ok := toFloat(b)
+		if !ok {
+			return typeError(word, want, b)
+		}
+		ans = fop(fa, fb)
+	}
+
+	vm.Stack[top-1] = ans
+	vm.Stack = vm.Stack[:top]
+	return nil
+}
+
+// toFloat converts ints and floats to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// flag converts a Go bool into a forth flag, where true is -1
+func flag(b bool) int {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// : - ( a b -- a-b ) <code>
+func subtract(vm *VM) error {
+	return arith(vm, "-",
+		func(a, b int) (interface{}, error) { return a - b, nil },
+		func(a, b float64) interface{} { return a - b })
+}
+
+// : / ( a b -- a/b ) <code>
+// Two ints give an int, rounded toward zero.
+func divide(vm *VM) error {
+	return arith(vm, "/",
+		func(a, b int) (interface{}, error) {
+			if b == 0 {
+				return nil, &ArgumentError{Word: "/", Reason: "division by zero"}
+			}
+			return a / b, nil
+		},
+		func(a, b float64) interface{} { return a / b })
+}
+
+// : mod ( a b -- a%b ) <code>
+func modulo(vm *VM) error {
+	return arith(vm, "mod",
+		func(a, b int) (interface{}, error) {
+			if b == 0 {
+				return nil, &ArgumentError{Word: "mod", Reason: "division by zero"}
+			}
+			return a % b, nil
+		}, nil)
+}
+
+// : and ( a b -- a&b ) <code>
+func bitAnd(vm *VM) error {
+	return arith(vm, "and", func(a, b int) (interface{}, error) { return a & b, nil }, nil)
+}
+
+// : or ( a b -- a|b ) <code>
+func bitOr(vm *VM) error {
+	return arith(vm, "or", func(a, b int) (interface{}, error) { return a | b, nil }, nil)
+}
+
+// : xor ( a b -- a^b ) <code>
+func bitXor(vm *VM) error {
+	return arith(vm, "xor", func(a, b int) (interface{}, error) { return a ^ b, nil }, nil)
+}
+
+// : invert ( a -- ~a ) <code>
+func invert(vm *VM) error {
+	a, err := vm.popInt("invert")
+	if err == nil {
+		vm.Push(^a)
+	}
+	return err
+}
+
+// : = ( a b -- flag ) <code>
+// Numbers are compared by value, whether they are ints or floats.
+func equals(vm *VM) error {
+	top := len(vm.Stack) - 1
+	if top < 1 {
+		return vm.underflow("=", 2)
+	}
+	a, b := vm.Stack[top-1], vm.Stack[top]
+	var eq bool
+	fa, aNum := toFloat(a)
+	fb, bNum := toFloat(b)
+	switch {
+	case aNum && bNum:
+		eq = fa == fb
+	case a == nil || b == nil:
+		eq = a == b
+	case reflect.TypeOf(a).Comparable() && reflect.TypeOf(b).Comparable():
+		eq = a == b
+	}
+	vm.Stack[top-1] = flag(eq)
+	vm.Stack = vm.Stack[:top]
+	return nil
+}
+
+// compare puts a flag on the stack according to `test', which gets
+// the ordering of the top two items: -1, 0, or 1.  Numbers compare
+// with numbers, and strings with strings.
+func compare(vm *VM, word string, test func(int) bool) error {
+	top := len(vm.Stack) - 1
+	if top < 1 {
+		return vm.underflow(word, 2)
+	}
+	a, b := vm.Stack[top-1], vm.Stack[top]
+	var order int
+	if sa, ok := a.(string); ok {
+		sb, ok := b.(string)
+		if !ok {
+			return typeError(word, "strings", b)
+		}
+		order = strings.Compare(sa, sb)
+	} else {
+		fa, ok := toFloat(a)
+		if !ok {
+			return typeError(word, "numbers or strings", a)
+		}
+		fb, ok := toFloat(b)
+		if !ok {
+			return typeError(word, "numbers", b)
+		}
+		switch {
+		case fa < fb:
+			order = -1
+		case fa > fb:
+			order = 1
+		}
+	}
+	vm.Stack[top-1] = flag(test(order))
+	vm.Stack = vm.Stack[:top]
+	return nil
+}
+
+// : < ( a b -- flag ) <code>
+func lessThan(vm *VM) error {
+	return compare(vm, "<", func(o int) bool { return o < 0 })
+}
+
+// : > ( a b -- flag ) <code>
+func greaterThan(vm *VM) error {
+	return compare(vm, ">", func(o int) bool { return o > 0 })
+}
+
 // numWordsInit adds numeric core words to the VM
 func numWordsInit(vm *VM) {
-	vm.Define("+", Word{add, false})
-	vm.Define("*", Word{multiply, false})
+	vm.Define("+", Word{Run: add, Effect: "( a b -- a+b )", Doc: "adds numbers, or joins strings"})
+	vm.Define("*", Word{Run: multiply, Effect: "( a b -- a*b )", Doc: "multiplies numbers, or repeats a string"})
+	vm.Define("-", Word{Run: subtract, Effect: "( a b -- a-b )", Doc: "subtracts numbers"})
+	vm.Define("/", Word{Run: divide, Effect: "( a b -- a/b )", Doc: "divides numbers, rounding toward zero for ints"})
+	vm.Define("mod", Word{Run: modulo, Effect: "( a b -- a%b )", Doc: "gives the remainder of dividing ints"})
+	vm.Define("and", Word{Run: bitAnd, Effect: "( a b -- a&b )", Doc: "gives the bitwise and of ints"})
+	vm.Define("or", Word{Run: bitOr, Effect: "( a b -- a|b )", Doc: "gives the bitwise or of ints"})
+	vm.Define("xor", Word{Run: bitXor, Effect: "( a b -- a^b )", Doc: "gives the bitwise exclusive-or of ints"})
+	vm.Define("invert", Word{Run: invert, Effect: "( a -- ~a )", Doc: "flips all the bits of an int"})
+	vm.Define("=", Word{Run: equals, Effect: "( a b -- flag )", Doc: "tests for equality, comparing numbers by value"})
+	vm.Define("<", Word{Run: lessThan, Effect: "( a b -- flag )", Doc: "tests whether a is less than b"})
+	vm.Define(">", Word{Run: greaterThan, Effect: "( a b -- flag )", Doc: "tests whether a is greater than b"})
 }
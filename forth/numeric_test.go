@@ -1,6 +1,7 @@
 package forth
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -15,3 +16,15 @@ func TestMul(t *testing.T) {
 		"hihihi", "yoyoyo")
 
 }
+
+func TestArith(t *testing.T) {
+	tstRunForth(t, `7 2 -  7 2 /  7 2 mod  7 2.0 /  -7 2 /`, 5, 3, 1, 3.5, -3)
+	tstRunForth(t, `6 3 and  6 3 or  6 3 xor  0 invert`, 2, 7, 5, -1)
+	if e := tstRunForthErr(t, `1 0 /`, 1, 0); !errors.Is(e, ErrArgument) {
+		t.Error(e)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tstRunForth(t, `1 2 <  1 2 >  2 2.0 =  " a" " b" <  " a" 1 =`, -1, 0, -1, -1, 0)
+}
@@ -0,0 +1,394 @@
+package forth
+
+import "strconv"
+
+// disassemble decodes the code from `start' to `end' for rewriting.
+// Each branch gets the index of the instruction it goes to as its
+// target, so instructions can be added and removed, and assemble
+// works the offsets back out.  The code has to be verified already.
+func (vm *VM) disassemble(start, end int) []insn {
+	code := vm.decode(&CompositeWord{start: start, end: end})
+	at := make(map[int]int, len(code)) // codeseg address -> index in code
+	for i := range code {
+		at[code[i].pos] = i
+		code[i].src = vm.posAt(code[i].pos)
+		code[i].target = -1
+	}
+	for i, in := range code {
+		if isBranch(in.op) {
+			code[i].target = at[branchTarget(in.pos, in.arg)]
+		}
+	}
+	return code
+}
+
+// assemble writes `code' into the codeseg from `start', replacing
+// everything from there on, with the offsets of the branches worked
+// out again.
+func (vm *VM) assemble(start int, code []insn) {
+	addr := make([]int, len(code))
+	p := start
+	for i, in := range code {
+		addr[i] = p
+		p += 1 + operands(in.op)
+	}
+
+	vm.codeseg = vm.codeseg[:start]
+	if len(vm.srcmap) > start {
+		vm.srcmap = vm.srcmap[:start]
+	}
+	for i, in := range code {
+		code[i].pos = addr[i]
+		vm.codeseg = append(vm.codeseg, in.op)
+		if operands(in.op) > 0 {
+			arg := in.arg
+			if isBranch(in.op) {
+				arg = uint32(addr[in.target] - addr[i] - 1)
+			}
+			vm.codeseg = append(vm.codeseg, arg)
+		}
+		vm.notePos(in.src)
+	}
+}
+
+// WithoutPeephole turns off the peephole pass the compiler runs at
+// `;', so `see' shows the code just as it was compiled, and every
+// word shows up in error traces, which helps when debugging.  Tail
+// calls are still made, since they change how deep words can call.
+func WithoutPeephole() Option {
+	return func(vm *VM) {
+		vm.noPeephole = true
+	}
+}
+
+// optimize rewrites the code of a word which was just compiled
+func (vm *VM) optimize(cw *CompositeWord) {
+	code := vm.disassemble(cw.start, cw.end)
+	if !vm.noPeephole {
+		if vm.inlines(code) {
+			cw.source = append([]insn(nil), code...)
+		}
+		code = vm.peephole(code)
+	}
+	vm.tailCalls(code)
+	vm.assemble(cw.start, code)
+	cw.end = len(vm.codeseg)
+}
+
+// tailCalls turns calls to composite words which are followed by a
+// return into tail calls, which reuse the caller's call frame.  So a
+// word can end by calling another, or itself, without the calls
+// nesting any deeper.
+func (vm *VM) tailCalls(code []insn) {
+	for i, in := range code {
+		if in.op > lastSpecial && vm.words[in.op].body != nil && returnsAt(code, i+1) {
+			code[i].op, code[i].arg = opTailCall, in.op
+		}
+	}
+}
+
+// returnsAt tells if the code at index `i' returns, following any
+// unconditional branches to get there
+func returnsAt(code []insn, i int) bool {
+	for n := 0; n < len(code) && i < len(code); n++ {
+		switch code[i].op {
+		case opReturn:
+			return true
+		case opBranch:
+			i = code[i].target
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// maxInline is the most instructions a word can have and still be
+// inlined into its callers
+const maxInline = 4
+
+// peephole inlines short words into `code', and then folds, fuses
+// and removes instructions until there is nothing more to do.  Each
+// of those passes only ever makes the code shorter.
+func (vm *VM) peephole(code []insn) []insn {
+	code = vm.inline(code)
+	for {
+		n := len(code)
+		code = deadCode(vm.fuse(vm.foldConstants(code)))
+		if len(code) == n {
+			return code
+		}
+	}
+}
+
+// splice rebuilds `code' with the instructions at the keys of `with'
+// replaced by the ones given, which can't be branches.  A branch to
+// a replaced instruction goes to what replaced it, or to whatever
+// follows when it was just removed.
+func splice(code []insn, with map[int][]insn) []insn {
+	if len(with) == 0 {
+		return code
+	}
+	at := make([]int, len(code)+1) // index in code -> index in out
+	out := make([]insn, 0, len(code))
+	for i, in := range code {
+		at[i] = len(out)
+		if w, ok := with[i]; ok {
+			out = append(out, w...)
+		} else {
+			out = append(out, in)
+		}
+	}
+	at[len(code)] = len(out)
+	for i := range out {
+		if isBranch(out[i].op) {
+			out[i].target = at[out[i].target]
+		}
+	}
+	return out
+}
+
+// jumpedTo gives the indexes in `code' which branches go to.  A
+// sequence of instructions can only be rewritten as one when nothing
+// jumps into the middle of it.
+func jumpedTo(code []insn) map[int]bool {
+	targets := make(map[int]bool)
+	for _, in := range code {
+		if isBranch(in.op) {
+			targets[in.target] = true
+		}
+	}
+	return targets
+}
+
+// isKernel tells if the word at `idx' is the kernel word `name'
+func (vm *VM) isKernel(idx uint32, name string) bool {
+	return idx > lastSpecial && int(idx) < len(vm.words) &&
+		vm.words[idx].name == name && vm.words[idx].Vocab == "kernel"
+}
+
+// costed tells if an instruction runs a word with a Cost of its own,
+// which the optimizer has to leave alone so the word is still charged
+// for every time it would have run
+func (vm *VM) costed(in insn) bool {
+	return in.op > lastSpecial && int(in.op) < len(vm.words) && vm.words[in.op].Cost != 0
+}
+
+// intLiteral gives the int an instruction pushes, if it's a literal
+func (vm *VM) intLiteral(in insn) (int, bool) {
+	switch {
+	case in.op == opLitINT:
+		return int(int32(in.arg)), true
+	case in.op == opLitUINT:
+		return int(in.arg), true
+	case in.op == opLitPOOL:
+		n, ok := vm.pool[in.arg].(int)
+		return n, ok
+	case in.op > lastSpecial && int(in.op) < len(vm.words) && vm.words[in.op].isLit:
+		n, ok := vm.words[in.op].lit.(int)
+		return n, ok
+	}
+	return 0, false
+}
+
+// foldConstants works out arithmetic on two int literals, like
+// `2 3 +', while compiling, leaving a literal of the result
+func (vm *VM) foldConstants(code []insn) []insn {
+	targets := jumpedTo(code)
+	with := make(map[int][]insn)
+	for i := 0; i+2 < len(code); i++ {
+		a, ok1 := vm.intLiteral(code[i])
+		b, ok2 := vm.intLiteral(code[i+1])
+		if !ok1 || !ok2 || targets[i+1] || targets[i+2] ||
+			vm.costed(code[i]) || vm.costed(code[i+1]) || vm.costed(code[i+2]) {
+			continue
+		}
+		op, ok := fastInts[vm.words[code[i+2].op].name]
+		if !ok || !vm.isKernel(code[i+2].op, vm.words[code[i+2].op].name) {
+			continue
+		}
+		lit := vm.literalInsn(op(a, b))
+		lit.src = code[i].src
+		with[i], with[i+1], with[i+2] = []insn{lit}, nil, nil
+		i += 2
+	}
+	return splice(code, with)
+}
+
+// fusions are the pairs of instructions which a single kernel word
+// does the work of.  A number stands for an int literal.
+var fusions = []struct{ first, second, fused string }{
+	{"swap", "drop", "(nip)"},
+	{"over", "over", "(2dup)"},
+	{"0", "=", "(0=)"},
+}
+
+// matches tells if an instruction is the kernel word `name', or
+// pushes the int it spells
+func (vm *VM) matches(in insn, name string) bool {
+	if n, err := strconv.Atoi(name); err == nil {
+		v, ok := vm.intLiteral(in)
+		return ok && v == n
+	}
+	return vm.isKernel(in.op, name)
+}
+
+// fuse replaces the pairs of instructions in `fusions' with the
+// word which does the same in one go
+func (vm *VM) fuse(code []insn) []insn {
+	targets := jumpedTo(code)
+	with := make(map[int][]insn)
+	for i := 0; i+1 < len(code); i++ {
+		if targets[i+1] || vm.costed(code[i]) || vm.costed(code[i+1]) {
+			continue
+		}
+		for _, f := range fusions {
+			idx, ok := vm.dict[f.fused]
+			if !ok || !vm.isKernel(idx, f.fused) || !vm.matches(code[i], f.first) || !vm.matches(code[i+1], f.second) {
+				continue
+			}
+			fused := insn{op: idx, target: -1, src: code[i].src}
+			with[i], with[i+1] = []insn{fused}, nil
+			i++
+			break
+		}
+	}
+	return splice(code, with)
+}
+
+// frameWords are the kernel words which can't be inlined, because
+// what they do depends on the call frame they run in
+var frameWords = map[string]bool{">r": true, "r>": true, "rdrop": true, "execute": true}
+
+// inlinable gives the code to put in place of a call to the word at
+// `idx', or nil if it has to be called.  Only short words without
+// branches, which just run literals and kernel words, get inlined,
+// and none with a Cost of their own.
+func (vm *VM) inlinable(idx uint32) []insn {
+	if idx <= lastSpecial || int(idx) >= len(vm.words) {
+		return nil
+	}
+	w := vm.words[idx]
+	if w.body == nil || w.Immediate || w.Cost != 0 {
+		return nil
+	}
+	body := vm.decode(w.body)
+	if len(body) > maxInline+1 || body[len(body)-1].op != opReturn {
+		return nil
+	}
+	body = body[:len(body)-1]
+	for _, in := range body {
+		switch {
+		case in.op == opLitINT || in.op == opLitUINT || in.op == opLitPOOL:
+		case in.op <= lastSpecial || int(in.op) >= len(vm.words) || vm.costed(in):
+			return nil
+		case vm.words[in.op].isLit:
+		case vm.words[in.op].body != nil || vm.words[in.op].Vocab != "kernel" || frameWords[vm.words[in.op].name]:
+			return nil
+		}
+	}
+	return body
+}
+
+// inline replaces calls to short words with their code, which saves
+// the call and lets the other passes work across it
+func (vm *VM) inline(code []insn) []insn {
+	with := make(map[int][]insn)
+	for i, in := range code {
+		body := vm.inlinable(in.op)
+		if body == nil {
+			continue
+		}
+		with[i] = make([]insn, len(body))
+		for j, b := range body {
+			with[i][j] = insn{op: b.op, arg: b.arg, target: -1, src: in.src}
+		}
+	}
+	return splice(code, with)
+}
+
+// inlines tells if inline will copy any words into `code'
+func (vm *VM) inlines(code []insn) bool {
+	for _, in := range code {
+		if vm.inlinable(in.op) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deadCode removes the instructions which can't be reached, after
+// an unconditional branch or a return.  The code still has to end
+// with a (RET), even if it is never reached.
+func deadCode(code []insn) []insn {
+	live := make([]bool, len(code))
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for ; i < len(code) && !live[i]; i++ {
+			live[i] = true
+			if isBranch(code[i].op) {
+				work = append(work, code[i].target)
+			}
+			if op := code[i].op; op == opBranch || op == opReturn || op == opTailCall {
+				break
+			}
+		}
+	}
+	last := len(code) - 1
+	for !live[last] {
+		last--
+	}
+	if code[last].op != opReturn {
+		live[len(code)-1] = true
+	}
+
+	with := make(map[int][]insn)
+	for i := range code {
+		if !live[i] {
+			with[i] = nil
+		}
+	}
+	return splice(code, with)
+}
+
+// nip ( a b -- b ) is `swap drop' in one go
+func nip(vm *VM) error {
+	top := len(vm.Stack)
+	if top < 2 {
+		return swap(vm)
+	}
+	vm.Stack[top-2] = vm.Stack[top-1]
+	vm.Stack = vm.Stack[:top-1]
+	return nil
+}
+
+// twoDup ( a b -- a b a b ) is `over over' in one go
+func twoDup(vm *VM) error {
+	top := len(vm.Stack)
+	if top < 2 {
+		return over(vm)
+	}
+	vm.Stack = append(vm.Stack, vm.Stack[top-2], vm.Stack[top-1])
+	return nil
+}
+
+// zeroEquals ( n -- flag ) is `0 =' in one go
+func zeroEquals(vm *VM) error {
+	top := len(vm.Stack) - 1
+	if top >= 0 {
+		if n, ok := vm.Stack[top].(int); ok {
+			vm.Stack[top] = flag(n == 0)
+			return nil
+		}
+	}
+	vm.Stack = append(vm.Stack, 0)
+	return equals(vm)
+}
+
+func optimizeWordsInit(vm *VM) {
+	vm.Define("(nip)", Word{Hidden: true, Run: nip, Effect: "( a b -- b )", Doc: "does `swap drop', for the optimizer"})
+	vm.Define("(2dup)", Word{Hidden: true, Run: twoDup, Effect: "( a b -- a b a b )", Doc: "does `over over', for the optimizer"})
+	vm.Define("(0=)", Word{Hidden: true, Run: zeroEquals, Effect: "( n -- flag )", Doc: "does `0 =', for the optimizer"})
+}
@@ -0,0 +1,130 @@
+package forth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// tstCode gives the compiled code of the word called `name'
+func tstCode(fvm *VM, name string) []insn {
+	return fvm.decode(fvm.words[fvm.dict[name]].body)
+}
+
+func TestTailCalls(t *testing.T) {
+	fvm := NewVM(WithLimits(Limits{Depth: 3}), WithoutPeephole())
+	code := `: w0 1 + ; : w1 w0 ; : w2 w1 ; : w3 w2 ; : w4 w3 ; : w5 w4 ;
+: pick dup if w5 else w0 then ;
+: early w0 exit w1 ;
+: count dup if 1 - over execute else drop then ;
+0 w5 1 pick 0 pick 5 early ' count 100000 count`
+	if err := fvm.Run(strings.NewReader(code), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(fvm.Stack) != 5 || fmt.Sprint(fvm.Stack[:4]) != "[1 2 1 6]" {
+		t.Errorf("wrong stack: %v", fvm.Stack)
+	}
+
+	for name, want := range map[string]int{"w5": 1, "pick": 2, "early": 2, "count": 0} {
+		n := 0
+		for _, in := range tstCode(fvm, name) {
+			if in.op == opTailCall {
+				n++
+			}
+		}
+		if n != want {
+			t.Errorf("%s: expected %d tail calls, found %d", name, want, n)
+		}
+	}
+	if see, _ := fvm.See("pick"); see != ": pick dup if w5 else w0 then ;" {
+		t.Errorf("wrong decompiled code: %s", see)
+	}
+	if err := fvm.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// a word called in tail position still sees the caller's rstack,
+	// which is cleaned up once the chain returns
+	for _, opts := range [][]Option{{WithoutPeephole()}, {WithoutPeephole(), WithThreadedCode()}} {
+		fvm = NewVM(opts...)
+		tstOutputOf(t, fvm, `: peek2 r@ ; : f2 5 >r peek2 ; : f3 f2 r@ ;`)
+		if fvm.Run(strings.NewReader(`f2`), ioutil.Discard); fmt.Sprint(fvm.Stack) != "[5]" || len(fvm.Rstack) != 0 {
+			t.Errorf("wrong stacks after a tail call: %v %v", fvm.Stack, fvm.Rstack)
+		}
+		fvm.ResetState()
+		if err := fvm.Run(strings.NewReader(`f3`), ioutil.Discard); !errors.Is(err, ErrRStackUnderflow) {
+			t.Errorf("expected the rstack to be cleaned when f2 returned, got %v", err)
+		}
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	defs := `: sq dup * ;
+: k 2 3 + 4 * ;
+: big 30000 30000 + ;
+: n 1 2 swap drop ;
+: d 1 2 over over ;
+: z 0 0 = ;
+: call-sq 3 sq ;
+: dead 1 exit 2 3 + ;
+: loop-fuse 1 swap begin drop dup until ;
+: forever 1 begin 1 + again ;
+`
+	for name, want := range map[string]string{
+		"k":         ": k 20 ;",
+		"big":       ": big 60000 ;",
+		"n":         ": n 1 2 (nip) ;",
+		"d":         ": d 1 2 (2dup) ;",
+		"z":         ": z -1 ;",
+		"call-sq":   ": call-sq 3 dup * ;",
+		"dead":      ": dead 1 ;",
+		"loop-fuse": ": loop-fuse 1 swap begin drop dup until ;",
+		"forever":   ": forever 1 begin 1 + again ;",
+	} {
+		fvm := NewVM()
+		if err := fvm.Run(strings.NewReader(defs), ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if see, _ := fvm.See(name); see != want {
+			t.Errorf("see %s:\n got %s\nwant %s", name, see, want)
+		}
+		if err := fvm.Verify(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the optimized code does the same as the code as it was compiled,
+	// though errors name the words it was turned into
+	for _, code := range []string{
+		"k big n d z call-sq dead",
+		": t 5 0 = 0 0 = 7 8 swap drop ; t",
+		": u 1 2 3 over over swap drop ; u",
+		`: v " a" 0 = ; v`,
+		": w swap drop ; w",
+		": x over over ; 1 x",
+		": y dup 0 = if 1 else 2 then ; 0 y 5 y",
+		tstBlocks + "3 blocks",
+	} {
+		var results []string
+		for _, opts := range [][]Option{nil, {WithoutPeephole()}} {
+			fvm := NewVM(opts...)
+			var out strings.Builder
+			err := fvm.Run(strings.NewReader(defs+code), &out)
+			results = append(results, fmt.Sprintf("%q %v %v", out.String(), fvm.Stack, errors.Is(err, ErrUnderflow)))
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s: optimized code differs:\n%s\n%s", code, results[0], results[1])
+		}
+	}
+
+	// and can be turned off
+	fvm := NewVM(WithoutPeephole())
+	if err := fvm.Run(strings.NewReader(defs), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if see, _ := fvm.See("k"); see != ": k 2 3 + 4 * ;" {
+		t.Errorf("unoptimized code changed: %s", see)
+	}
+}
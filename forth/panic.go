@@ -0,0 +1,42 @@
+package forth
+
+import "runtime/debug"
+
+// WithPanicRecovery makes the VM recover from panics in the words
+// it runs, failing with a *PanicError instead of taking down the
+// program.  After one, the VM needs a ResetState like after any
+// other error.
+func WithPanicRecovery() Option {
+	return func(vm *VM) {
+		vm.recoverPanics = true
+	}
+}
+
+// safeRun runs the word at `idx', turning a panic into an error
+func (vm *VM) safeRun(idx uint32) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Word: vm.wordName(idx), Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return vm.words[idx].Run(vm)
+}
+
+// recoverInner turns a panic in the inner interpreter, running the
+// calls above `base', into a *PanicError naming the word whose code
+// it was running, traced like any other error from that code.
+func (vm *VM) recoverInner(base int, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	pe := &PanicError{Value: r, Stack: debug.Stack()}
+	if len(vm.calls) > base {
+		pe.Word = vm.calls[len(vm.calls)-1].word.name
+	}
+	idx := uint32(opReturn)
+	if vm.ip >= 0 && vm.ip < len(vm.codeseg) {
+		idx = vm.codeseg[vm.ip]
+	}
+	*err = vm.unwind(base, pe, idx, vm.ip)
+}
@@ -0,0 +1,60 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	fvm := NewVM(WithPanicRecovery(), func(fvm *VM) {
+		fvm.Define("boom", Word{Run: func(fvm *VM) error {
+			var m map[string]int
+			m["x"] = 1
+			return nil
+		}})
+	})
+	err := fvm.Run(strings.NewReader(": f 1 boom ; 2 f"), ioutil.Discard)
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Word != "boom" || !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected a panic error, got %v", err)
+	}
+	if pe.Value == nil || !strings.Contains(string(pe.Stack), "panic_test.go") {
+		t.Errorf("panic value or stack missing: %v\n%s", pe.Value, pe.Stack)
+	}
+	var e *Error
+	if !errors.As(err, &e) || len(e.Trace) != 1 || e.Trace[0].Word != "f" {
+		t.Errorf("panic not traced through f: %v", err)
+	}
+
+	fvm.ResetState()
+	if err := fvm.Run(strings.NewReader("1 2 +"), ioutil.Discard); err != nil || len(fvm.Stack) != 1 {
+		t.Errorf("VM not usable after a panic: %v", err)
+	}
+}
+
+func TestPanicInnerLoop(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithThreadedCode()}} {
+		fvm := NewVM(append(opts, WithPanicRecovery(), WithoutPeephole())...)
+		tstOutputOf(t, fvm, ": g 1 2 + ; : f g ;")
+
+		// a bad cell in g's code makes the inner interpreter itself
+		// panic, rather than any word it runs
+		fvm.codeseg[fvm.words[fvm.dict["g"]].body.start] = 1 << 30
+		err := fvm.Run(strings.NewReader("f"), ioutil.Discard)
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expected a panic error, got %v", err)
+		}
+		var e *Error
+		if !errors.As(err, &e) || len(e.Trace) != 2 || e.Trace[0].Word != "g" || e.Trace[1].Word != "f" {
+			t.Errorf("panic not traced through g and f: %v", err)
+		}
+
+		fvm.ResetState()
+		if err := fvm.Run(strings.NewReader("1 2 +"), ioutil.Discard); err != nil || len(fvm.Stack) != 1 {
+			t.Errorf("VM not usable after a panic: %v", err)
+		}
+	}
+}
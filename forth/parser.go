@@ -3,57 +3,238 @@ package forth
 import (
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
 // CompositeWord represents a word made up of opcodes for other defined words
 type CompositeWord struct {
-	start int
+	start  int // where the code starts in the codeseg
+	end    int // just past the final (RET)
+	name   string
+	thread []cell // the threaded form of the code, once it has run
+	source []insn // the code before it was optimized, if words were inlined into it
 }
 
-// Run on a composite word:
-// don't use the typical return stack... use
-// Go's stack instead... this can make the RStack
-// an auto-cleaned scratch space, which doesn't have
-// to remain balanced like a typical FORTH.
-// The only downside is you can't play return-address games
-// to force double exits or delayed tail calls.  But, from
-// what I've seen on c.l.f, that kind of behavior doesn't
+// Run on a composite word runs its code, and the code of any
+// composite words it calls, in a single loop.  Rather than recursing
+// on the Go stack, each call pushes a callFrame on the VM, so the
+// depth of calls is only limited by Limits.Depth.  Words written in
+// Go that call back into forth, like `evaluate', just start another
+// loop on top of the same frames.
+//
+// The RStack is still an auto-cleaned scratch space, which doesn't
+// have to remain balanced like a typical FORTH: returning from a
+// word drops anything it left there.  So you can't play
+// return-address games to force double exits or delayed tail calls.
+// But, from what I've seen on c.l.f, that kind of behavior doesn't
 // work on all FORTHS anyway.
-func (c CompositeWord) Run(vm *VM) error {
-	// setup the composite word
-	rstackLen := len(vm.Rstack)
-	oldIP := vm.ip
-	vm.ip = c.start
+func (c *CompositeWord) Run(vm *VM) (err error) {
+	base := len(vm.calls)
+	if vm.recoverPanics {
+		defer vm.recoverInner(base, &err)
+	}
+	if err := vm.enter(c); err != nil {
+		return err
+	}
+	if vm.threaded {
+		return vm.runThreaded(base)
+	}
 
-	// run the internal words
 	for {
 		idx := vm.codeseg[vm.ip]
-		if idx == opReturn {
-			break
+		here := vm.ip
+		var err error
+		switch body := vm.words[idx].body; {
+		case idx == opReturn:
+			var done bool
+			if done, err = vm.leave(base); done {
+				return err
+			}
+		case idx == opTailCall:
+			callee := vm.codeseg[vm.ip+1]
+			if err = vm.tailEnter(callee); err != nil {
+				return vm.unwind(base, err, callee, here)
+			}
+			continue
+		case body != nil:
+			if vm.metering {
+				err = vm.meter(idx)
+			}
+			if err == nil {
+				err = vm.enter(body)
+			}
+			if err == nil {
+				continue
+			}
+		default:
+			if err = vm.dispatch(idx, true); err == nil {
+				err = vm.step()
+			}
 		}
-		if err := vm.words[idx].Run(vm); err != nil {
-			return err
+		if err != nil {
+			return vm.unwind(base, err, idx, here)
 		}
 		vm.ip++
 	}
+}
+
+// leave returns from the running composite word, to its caller.  It
+// is done when that takes it back down to the `base' of the calls
+// the inner interpreter was started with, or when there's an error.
+func (vm *VM) leave(base int) (done bool, err error) {
+	f := vm.calls[len(vm.calls)-1]
+	if err = vm.cleanRstack(f); err != nil {
+		return true, vm.unwind(base, err, opReturn, vm.ip)
+	}
+	vm.ip = f.ret
+	vm.calls = vm.calls[:len(vm.calls)-1]
+	return len(vm.calls) == base, nil
+}
+
+// tailEnter replaces the running composite word with the one at
+// `idx', which takes over its call frame.  Whatever the caller left
+// on the rstack stays there for the callee, just as it would for a
+// call, and is dropped when the last word of the chain returns.
+func (vm *VM) tailEnter(idx uint32) error {
+	f := &vm.calls[len(vm.calls)-1]
+	var err error
+	if vm.metering {
+		err = vm.meter(idx)
+	}
+	if err == nil {
+		err = vm.step()
+	}
+	if err != nil {
+		return err
+	}
+	f.via, f.viaIP = f.word, vm.ip
+	f.word = vm.words[idx].body
+	vm.ip = f.word.start
+	return nil
+}
 
-	if len(vm.Rstack) < rstackLen {
-		return ErrRStackUnderflow
+// returnsNext tells if the running composite word returns right
+// after the current instruction, following any unconditional
+// branches to get there.
+func (vm *VM) returnsNext() bool {
+	ip := vm.ip + 1 + operands(vm.codeseg[vm.ip])
+	for n := 0; n < 16 && ip < len(vm.codeseg); n++ {
+		switch vm.codeseg[ip] {
+		case opReturn:
+			return true
+		case opBranch:
+			ip = branchTarget(ip, vm.codeseg[ip+1])
+		default:
+			return false
+		}
 	}
+	return false
+}
 
-	// clean up the rstack and exit
-	vm.Rstack = vm.Rstack[:rstackLen]
-	vm.ip = oldIP
+// cleanRstack drops what the word running in `f' left on the
+// rstack, as it returns, failing if it took away too much instead.
+func (vm *VM) cleanRstack(f callFrame) error {
+	if len(vm.Rstack) < f.rstackLen {
+		return &StackError{Word: f.word.name, Need: f.rstackLen, Have: len(vm.Rstack), Return: true}
+	}
+	vm.Rstack = vm.Rstack[:f.rstackLen]
 	return nil
 }
 
+// tailCall ('(tailcall)') is handled by the inner interpreter, so it
+// only runs if something calls it some other way.
+func tailCall(vm *VM) error {
+	return &StateError{Word: "(tailcall)", Reason: "only runs inside a composite word"}
+}
+
+// callFrame is a call to a composite word in progress
+type callFrame struct {
+	word      *CompositeWord
+	ret       int // the ip to go back to
+	rstackLen int // the depth of the rstack when it was called
+
+	// the last word which tail-called this one, and where, which
+	// only matters for the traces of errors
+	via   *CompositeWord
+	viaIP int
+}
+
+// enter starts running the composite word `c', as long as that
+// doesn't nest calls too deeply.
+func (vm *VM) enter(c *CompositeWord) error {
+	if max := vm.limits.maxDepth(); len(vm.calls) >= max {
+		return &LimitError{Err: ErrDepthLimit, Max: max}
+	}
+	if err := vm.step(); err != nil {
+		return err
+	}
+	vm.calls = append(vm.calls, callFrame{word: c, ret: vm.ip, rstackLen: len(vm.Rstack)})
+	vm.ip = c.start
+	return nil
+}
+
+// unwind abandons the calls above `base' after an error in the word
+// at `idx', while at `ip'.  Each one goes in the trace of the error,
+// and the error is wrapped with where it happened if it isn't
+// already.
+func (vm *VM) unwind(base int, err error, idx uint32, ip int) error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Err: err, Word: vm.wordName(idx), Pos: vm.posAt(ip)}
+	}
+	for i := len(vm.calls) - 1; i >= base; i-- {
+		f := vm.calls[i]
+		e.Trace = append(e.Trace, Frame{Word: f.word.name, Pos: vm.posAt(ip)})
+		if f.via != nil {
+			e.Trace = append(e.Trace, Frame{Word: f.via.name, Pos: vm.posAt(f.viaIP)})
+		}
+		ip = f.ret
+	}
+	vm.calls = vm.calls[:base]
+	vm.ip = ip
+	return e
+}
+
+// unknownWord builds the error for a token which isn't a word or
+// a literal, with suggestions for what might have been meant.
+func (vm *VM) unknownWord(err error, str string, pos Pos) error {
+	return &Error{Err: err, Word: str, Pos: pos, Suggestions: vm.suggest(str)}
+}
+
 // parenComment '(' skips until the closing paren.
 // : ( ')' skip ; immediate
+// When it comes right after the name in a definition, the
+// comment is kept as the word's stack effect.
 func parenComment(vm *VM) error {
-	vm.Push(int(')'))
-	return skip(vm)
+	if !vm.Compiling || len(vm.codeseg) != vm.curdef || vm.curmeta.Effect != "" {
+		vm.Push(int(')'))
+		return skip(vm)
+	}
+	buf, err := delimitedRead(vm.src, ')', nil)
+	if err == nil {
+		vm.curmeta.Effect = "( " + strings.TrimSpace(string(buf)) + " )"
+	}
+	return err
+}
+
+// docString ('doc"') reads a string up to the next double quote as
+// the documentation for the word being defined, or for the newest
+// word when not compiling.
+func docString(vm *VM) error {
+	buf, err := delimitedRead(vm.src, '"', nil)
+	if err != nil {
+		return err
+	}
+	doc := strings.TrimSpace(string(buf))
+	if vm.Compiling {
+		vm.curmeta.Doc = doc
+	} else if len(vm.words) > 0 {
+		vm.words[len(vm.words)-1].Doc = doc
+	}
+	return nil
 }
 
 // nlComment '\' skips until the next newline
@@ -63,14 +244,11 @@ func nlComment(vm *VM) error {
 	return skip(vm)
 }
 
+// nextToken reads a whitespace-delimited token from the input,
+// lowercased, and remembers where it came from in vm.tok
 func nextToken(vm *VM, buf []rune) (string, error) {
-	ch, err := eatWhitespace(vm.Source)
-	if err != nil {
-		return "", err
-	}
-
-	buf = append(buf, ch)
-	if buf, err = delimitedWSRead(vm.Source, buf); err == nil {
+	buf, err := rawToken(vm, buf)
+	if err == nil {
 		// convert the buffer to lowercase
 		for i, r := range buf {
 			buf[i] = unicode.ToLower(r)
@@ -80,6 +258,19 @@ func nextToken(vm *VM, buf []rune) (string, error) {
 	return string(buf), err
 }
 
+// rawToken reads a whitespace-delimited token from the input
+// as-is, and remembers where it came from in vm.tok
+func rawToken(vm *VM, buf []rune) ([]rune, error) {
+	ch, err := eatWhitespace(vm.src)
+	if err != nil {
+		return buf, err
+	}
+	vm.tok = vm.src.last
+
+	buf = append(buf, ch)
+	return delimitedWSRead(vm.src, buf)
+}
+
 // decodeLiteral possibly turns a string into a number,
 // and maybe other literal forms if I want to do so later.
 func decodeLiteral(s string) (interface{}, error) {
@@ -96,14 +287,14 @@ func decodeLiteral(s string) (interface{}, error) {
 	}
 
 	// we can't tell what this token is!
-	return nil, fmt.Errorf("unknown token <%s>", s)
+	return nil, &UnknownWordError{Name: s}
 }
 
 // stopInterpret completes an interpretation and falls back to the compiler
 // (assuming one was in play
 func stopInterpret(vm *VM) error {
 	if vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: "]", Reason: "already compiling"}
 	}
 	vm.Compiling = true
 	return nil
@@ -113,7 +304,7 @@ func stopInterpret(vm *VM) error {
 // reads words one at a time...
 func interpret(vm *VM) (err error) {
 	if !vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: "[", Reason: "already interpreting"}
 	}
 
 	vm.Compiling = false
@@ -129,58 +320,171 @@ func interpret(vm *VM) (err error) {
 			return
 		}
 
+		pos := vm.tok
+
 		// lookup the string in the dictionary
-		if idx, ok := vm.dict[str]; ok {
-			err = vm.words[idx].Run(vm)
+		if idx, ok := vm.findWord(str); ok {
+			if err = vm.checkUse(idx, pos, false); err == nil {
+				err = vm.dispatch(idx, false)
+			}
 		} else {
 			// if it's not there, put it on the stack as a literal
 			var lit interface{}
 			if lit, err = decodeLiteral(str); err == nil {
 				vm.Push(lit)
+			} else {
+				err = vm.unknownWord(err, str, pos)
 			}
 		}
+		if err == nil {
+			err = vm.step()
+		}
+		if err != nil {
+			err = wrapError(err, str, pos)
+		}
 	}
 	return
 }
 
-// func makeImmediate ('immediate') makes the last defined word immediate
-func makeImmediate(vm *VM) error {
-	vm.words[len(vm.words)-1].Immediate = true
+// flagNewest gives the Run function for a word like `immediate',
+// which sets a flag on the newest word
+func flagNewest(set func(*Word)) func(*VM) error {
+	return func(vm *VM) error {
+		set(&vm.words[len(vm.words)-1])
+		return nil
+	}
+}
+
+// checkUse makes sure the word at `idx' can be used where it is, at
+// `pos', and warns when it is deprecated
+func (vm *VM) checkUse(idx uint32, pos Pos, compiling bool) error {
+	w := &vm.words[idx]
+	switch {
+	case w.CompileOnly && !compiling:
+		return &StateError{Word: w.name, Reason: "only works in a definition"}
+	case w.InterpretOnly && compiling:
+		return &StateError{Word: w.name, Reason: "doesn't work in a definition"}
+	case w.Deprecated:
+		vm.warn(pos, "%s is deprecated", w.name)
+	}
 	return nil
 }
 
 // stopCompile (';') terminates a compilation
 func stopCompile(vm *VM) error {
 	if !vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: ";", Reason: "not compiling"}
+	}
+	if l := len(vm.cstack) - 1; l >= 0 {
+		c := vm.cstack[l]
+		return &StructureError{Word: ";", Open: ctlNames[c.kind], Pos: c.pos}
 	}
-	vm.Compiling = false
 	vm.codeseg = append(vm.codeseg, opReturn) // put a (RET)
+	vm.notePos(vm.tok)
 
-	// create a composite word out of the current definition
-	cw := CompositeWord{start: vm.curdef}
-	vm.Define(vm.curname, Word{Run: cw.Run, Immediate: false})
+	// create a composite word out of the current definition, unless
+	// playing games with compile, left code which can't be run
+	cw := CompositeWord{start: vm.curdef, end: len(vm.codeseg), name: vm.curname}
+	if err := vm.verifyWord(&cw); err != nil {
+		return err
+	}
+	vm.Compiling = false
+	vm.curundo = nil
+	vm.optimize(&cw)
+	word := vm.curmeta
+	word.Run, word.body = cw.Run, &cw
+	old, shadows := vm.findWord(vm.curname)
+	vm.Define(vm.curname, word)
+	if shadows && (vm.currebind || vm.lateBinding) {
+		vm.rebind(old, uint32(len(vm.words)-1), vm.curpos)
+	}
 	return nil
 }
 
 // compile (':') reads the name of a word to define, and then compiles
 // the definition until ';' tells it to stop
-func compile(vm *VM) (err error) {
+func compile(vm *VM) error {
+	return beginDefinition(vm, ":", false)
+}
+
+// beginDefinition starts a definition for `word', which is `:' or
+// `redefine'.  With `rebind', callers of the word it replaces are
+// pointed at it once it is finished.
+func beginDefinition(vm *VM, word string, rebind bool) (err error) {
 	if vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: word, Reason: "already compiling"}
 	}
 
 	vm.Compiling = true
+	from := vm.tok
 
 	buf := make([]rune, 0, 20)
 
 	// STEP 1: read the name
 	var str string
 	str, err = nextToken(vm, buf)
+	if err != nil {
+		return wrapError(err, word, vm.tok)
+	}
+	if _, ok := vm.findWord(str); rebind && !ok {
+		vm.Compiling = false
+		return wrapError(&UnknownWordError{Name: str}, word, vm.tok)
+	}
 	vm.curname = str            // remember the name of the definition
 	vm.curdef = len(vm.codeseg) // remember the start of the definition
+	vm.curpos, vm.currebind = vm.tok, rebind
+	vm.curmeta = Word{File: vm.tok.Source, Line: vm.tok.Line}
+	vm.curundo = &undoDef{
+		mark:  vm.markHere(),
+		stack: append([]interface{}(nil), vm.Stack...),
+		word:  word,
+		pos:   from,
+		depth: len(vm.sources),
+	}
+	vm.cstack = vm.cstack[:0]
 
+	return compileTokens(vm)
+}
+
+// undoDef is what it takes to undo a definition which failed: the
+// state of the VM and its stack at the `:'
+type undoDef struct {
+	mark  markState
+	stack []interface{}
+	word  string // the word which started it, `:' or `redefine'
+	pos   Pos    // where that word was
+	depth int    // how deeply the source it started in is nested
+}
+
+// abandonDefinition undoes the definition being compiled, when it
+// fails.  The code compiled so far, any literals and words it added,
+// and whatever its control structures left on the stack all go, so
+// the VM is just as it was before the `:'.
+func (vm *VM) abandonDefinition() {
+	_ = vm.rollback(":", vm.curundo.mark)
+	vm.Stack = vm.curundo.stack
+	vm.curundo, vm.curname, vm.Compiling = nil, "", false
+	vm.cstack = vm.cstack[:0]
+}
+
+// unfinished reports a definition which is still open at the end of
+// the source it started in, for `word', and undoes it just as if it
+// had failed.  A definition can't carry on into another source.
+func (vm *VM) unfinished(word string) error {
+	u := vm.curundo
+	if u == nil || u.depth != len(vm.sources) {
+		return nil
+	}
+	vm.abandonDefinition()
+	return wrapError(&StructureError{Word: word, Open: u.word, Pos: u.pos}, word, u.pos)
+}
+
+// compileTokens reads tokens and compiles them, until something
+// turns off vm.Compiling or the input runs out.
+func compileTokens(vm *VM) (err error) {
+	buf := make([]rune, 0, 20)
 	for (err == nil) && vm.Compiling {
+		var str string
 		str, err = nextToken(vm, buf)
 		if err != nil {
 			if err == io.EOF {
@@ -188,13 +492,17 @@ func compile(vm *VM) (err error) {
 			}
 			return
 		}
+		pos := vm.tok
 
 		// lookup the string in the dictionary
-		if idx, ok := vm.dict[str]; ok {
+		if idx, ok := vm.findWord(str); ok {
 			// compile in the word unless it's immediate
-			if vm.words[idx].Immediate {
-				err = vm.words[idx].Run(vm)
-			} else {
+			err = vm.checkUse(idx, pos, true)
+			switch {
+			case err != nil:
+			case vm.words[idx].Immediate:
+				err = vm.dispatch(idx, false)
+			default:
 				vm.codeseg = append(vm.codeseg, idx)
 			}
 		} else {
@@ -202,23 +510,35 @@ func compile(vm *VM) (err error) {
 			var lit interface{}
 			if lit, err = decodeLiteral(str); err == nil {
 				compileLiteral(vm, lit)
+			} else {
+				err = vm.unknownWord(err, str, pos)
+			}
+		}
+		vm.notePos(pos)
+		if err == nil {
+			err = vm.step()
+		}
+		if err != nil {
+			err = wrapError(err, str, pos)
+			if vm.curundo != nil {
+				vm.abandonDefinition()
 			}
 		}
 	}
 	return
 }
 
-// (litINT) reads the next 16-bits from the codeseg and pushes that number on the stack as an int
-// The 16 bits are considered signed
+// (litINT) reads the next 32-bits from the codeseg and pushes that number on the stack as an int
+// The 32 bits are considered signed
 func litINT(vm *VM) error {
 	vm.ip++
-	num := int16(vm.codeseg[vm.ip])
+	num := int32(vm.codeseg[vm.ip])
 	vm.Stack = append(vm.Stack, int(num))
 	return nil
 }
 
-// (litUINT) reads the next 16-bits from the codeseg and pushes that number on the stack as an int
-// The 16 bits are considered unsigned
+// (litUINT) reads the next 32-bits from the codeseg and pushes that number on the stack as an int
+// The 32 bits are considered unsigned
 func litUINT(vm *VM) error {
 	vm.ip++
 	num := vm.codeseg[vm.ip]
@@ -227,32 +547,37 @@ func litUINT(vm *VM) error {
 }
 
 // compileLiteral is a helper function to put a literal into the compiled
-// codestream. This will be the one place we'll have to add code to have more
-// special types that don't just go to CreatePusher()
+// codestream.
 func compileLiteral(vm *VM, value interface{}) {
-	switch num := value.(type) {
-	case int:
+	in := vm.literalInsn(value)
+	vm.codeseg = append(vm.codeseg, in.op)
+	if operands(in.op) > 0 {
+		vm.codeseg = append(vm.codeseg, in.arg)
+	}
+}
+
+// literalInsn gives the instruction which pushes a literal.  Ints
+// which fit go right in the code, and the rest go in the pool.
+func (vm *VM) literalInsn(value interface{}) insn {
+	if num, ok := value.(int); ok {
 		switch {
-		case (num >= -32768) && (num < 32768):
-			vm.codeseg = append(vm.codeseg, opLitINT, uint16(num))
-		case (num >= 0) && (num < 65536):
-			vm.codeseg = append(vm.codeseg, opLitUINT, uint16(num))
-		default:
-			vm.codeseg = append(vm.codeseg, vm.CreatePusher(num))
+		case (num >= math.MinInt32) && (num <= math.MaxInt32):
+			return insn{op: opLitINT, arg: uint32(num), target: -1}
+		case (num >= 0) && (int64(num) <= math.MaxUint32):
+			return insn{op: opLitUINT, arg: uint32(num), target: -1}
 		}
-	default:
-		vm.codeseg = append(vm.codeseg, vm.CreatePusher(value))
 	}
+	return insn{op: opLitPOOL, arg: vm.poolLiteral(value), target: -1}
 }
 
-// literal is an immediate word that reads an int from the stack and compiles it into the codestream
-// if possible, and uses a pusher if necessary.
+// literal is an immediate word that reads a value from the stack and compiles it into the codestream
+// if possible, and puts it in the literal pool if necessary.
 func literal(vm *VM) (err error) {
 	if !vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: "literal", Reason: "not compiling"}
 	}
 	var value interface{}
-	value, err = vm.Pop()
+	value, err = vm.pop("literal")
 	if err != nil {
 		return
 	}
@@ -263,17 +588,16 @@ func literal(vm *VM) (err error) {
 // compileComma takes the top of the stack and puts that opcode literally
 // into the code sequence.
 func compileComma(vm *VM) error {
-	value, err := vm.Pop()
+	num, err := vm.popInt("compile,")
 	if err != nil {
 		return err
 	}
 
-	num, ok := value.(int)
-	if !ok || (num < 0) || (num > len(vm.words)) {
-		return ErrArgument
+	if (num < 0) || (num >= len(vm.words)) {
+		return &ArgumentError{Word: "compile,", Reason: fmt.Sprintf("no word has index %d", num)}
 	}
 
-	vm.codeseg = append(vm.codeseg, uint16(num))
+	vm.codeseg = append(vm.codeseg, uint32(num))
 	return nil
 }
 
@@ -281,7 +605,7 @@ func compileComma(vm *VM) error {
 // immediates, it creates code that calls code in the caller.
 func postpone(vm *VM) error {
 	if !vm.Compiling {
-		return ErrBadState
+		return &StateError{Word: "postpone", Reason: "not compiling"}
 	}
 
 	buf := make([]rune, 0, 20)
@@ -292,9 +616,9 @@ func postpone(vm *VM) error {
 		return err
 	}
 
-	opcode, ok := vm.dict[str]
+	opcode, ok := vm.findWord(str)
 	if !ok {
-		return fmt.Errorf("POSTPONE: no word <%s>", str)
+		return vm.unknownWord(&UnknownWordError{Name: str}, str, vm.tok)
 	}
 
 	// STEP 2: generate the code
@@ -309,14 +633,79 @@ func postpone(vm *VM) error {
 	return nil
 }
 
+// tick (') reads the name of a word, and pushes its execution token
+func tick(vm *VM) error {
+	str, err := nextToken(vm, make([]rune, 0, 20))
+	if err != nil {
+		return err
+	}
+	xt, ok := vm.findWord(str)
+	if !ok {
+		return vm.unknownWord(&UnknownWordError{Name: str}, str, vm.tok)
+	}
+	vm.Push(int(xt))
+	return nil
+}
+
+// : ['] ' postpone literal ; immediate
+func bracketTick(vm *VM) error {
+	if !vm.Compiling {
+		return &StateError{Word: "[']", Reason: "not compiling"}
+	}
+	if err := tick(vm); err != nil {
+		return err
+	}
+	return literal(vm)
+}
+
+// execute ( xt -- ) runs the word with the given execution token.
+// Called from a composite word, a composite word is run by the same
+// inner interpreter, so it takes no Go stack, and in tail position
+// it takes no call frame either.
+func execute(vm *VM) error {
+	xt, err := vm.popXT("execute")
+	if err != nil {
+		return err
+	}
+	inner := vm.inner
+	if body := vm.words[xt].body; body != nil && inner {
+		// let the inner interpreter run it, rather than recursing,
+		// and as a tail call if nothing is left to do after it
+		if vm.returnsNext() {
+			if err = vm.tailEnter(xt); err == nil {
+				vm.ip-- // the inner interpreter steps forward after we return
+			}
+			return err
+		}
+		if vm.metering {
+			if err = vm.meter(xt); err != nil {
+				return err
+			}
+		}
+		if err = vm.enter(body); err == nil {
+			vm.ip-- // the inner interpreter steps forward after we return
+		}
+		return err
+	}
+	return vm.dispatch(xt, inner)
+}
+
 func parseWordsInit(vm *VM) {
-	vm.Define("\\", Word{nlComment, true})
-	vm.Define("(", Word{parenComment, true})
-	vm.Define("[", Word{interpret, true})
-	vm.Define("]", Word{stopInterpret, false})
-	vm.Define(":", Word{compile, false})
-	vm.Define(";", Word{stopCompile, true})
-	vm.Define("literal", Word{literal, true})
-	vm.Define("postpone", Word{postpone, true})
-	vm.Define("immediate", Word{makeImmediate, false})
+	vm.Define("\\", Word{Run: nlComment, Immediate: true, Effect: "( \"text<newline>\" -- )", Doc: "skips the rest of the line"})
+	vm.Define("doc\"", Word{Run: docString, Immediate: true, Effect: "( \"text<quote>\" -- )", Doc: "documents the word being defined, or the newest word"})
+	vm.Define("(", Word{Run: parenComment, Immediate: true, Effect: "( \"text<paren>\" -- )", Doc: "skips input up to the closing paren"})
+	vm.Define("[", Word{Run: interpret, Immediate: true, Effect: "( -- )", Doc: "switches to interpreting"})
+	vm.Define("]", Word{Run: stopInterpret, Effect: "( -- )", Doc: "switches back to compiling"})
+	vm.Define(":", Word{Run: compile, InterpretOnly: true, Effect: "( \"name\" -- )", Doc: "starts a definition"})
+	vm.Define(";", Word{Run: stopCompile, Immediate: true, CompileOnly: true, Effect: "( -- )", Doc: "ends a definition"})
+	vm.Define("literal", Word{Run: literal, Immediate: true, CompileOnly: true, Effect: "( x -- )", Doc: "compiles x into the current definition"})
+	vm.Define("postpone", Word{Run: postpone, Immediate: true, CompileOnly: true, Effect: "( \"name\" -- )", Doc: "compiles the compilation behavior of a word"})
+	vm.Define("immediate", Word{Run: flagNewest(func(w *Word) { w.Immediate = true }), Effect: "( -- )", Doc: "makes the newest word immediate"})
+	vm.Define("compile-only", Word{Run: flagNewest(func(w *Word) { w.CompileOnly = true }), Effect: "( -- )", Doc: "makes the newest word work only in definitions"})
+	vm.Define("interpret-only", Word{Run: flagNewest(func(w *Word) { w.InterpretOnly = true }), Effect: "( -- )", Doc: "makes the newest word work only outside definitions"})
+	vm.Define("hidden", Word{Run: flagNewest(func(w *Word) { w.Hidden = true }), Effect: "( -- )", Doc: "hides the newest word, so it can't be found by name"})
+	vm.Define("deprecated", Word{Run: flagNewest(func(w *Word) { w.Deprecated = true }), Effect: "( -- )", Doc: "makes using the newest word give a warning"})
+	vm.Define("'", Word{Run: tick, Effect: "( \"name\" -- xt )", Doc: "gives the execution token of a word"})
+	vm.Define("[']", Word{Run: bracketTick, Immediate: true, CompileOnly: true, Effect: "( \"name\" -- )", Doc: "compiles the execution token of a word as a literal"})
+	vm.Define("execute", Word{Run: execute, Effect: "( xt -- )", Doc: "runs the word with the given execution token"})
 }
@@ -12,3 +12,8 @@ func TestLiterals(t *testing.T) {
 func TestUpCase(t *testing.T) {
 	tstRunForth(t, `: TST 3 4 sWaP ; tst`, 4, 3) 
 }
+
+func TestTick(t *testing.T) {
+	tstRunForth(t, `2 ' dup execute`, 2, 2)
+	tstRunForth(t, `: twice ['] dup execute + ; 4 twice`, 8)
+}
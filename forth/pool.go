@@ -0,0 +1,82 @@
+package forth
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// The literal pool holds the literals which don't fit in a cell, like
+// strings, floats and big ints.  The code pushes one with (litPOOL)
+// and its index in the pool, and the same literal compiled twice
+// shares an entry.
+
+// shareable tells if a literal can be looked up in the pool, which
+// needs it to be comparable
+func shareable(v interface{}) bool {
+	return v != nil && reflect.TypeOf(v).Comparable()
+}
+
+// floatBits keys a float in the pool index by its bits, since -0.0
+// and 0.0 are equal, but aren't the same literal
+type floatBits uint64
+
+// poolKey gives what to look `v' up in the pool index by
+func poolKey(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		return floatBits(math.Float64bits(f))
+	}
+	return v
+}
+
+// poolLiteral gives the index in the pool of `v', adding it if it
+// isn't there yet
+func (vm *VM) poolLiteral(v interface{}) uint32 {
+	if !shareable(v) {
+		vm.pool = append(vm.pool, v)
+		return uint32(len(vm.pool) - 1)
+	}
+	key := poolKey(v)
+	if idx, ok := vm.poolIndex[key]; ok {
+		return idx
+	}
+	if vm.poolIndex == nil {
+		vm.poolIndex = make(map[interface{}]uint32)
+	}
+	vm.pool = append(vm.pool, v)
+	vm.poolIndex[key] = uint32(len(vm.pool) - 1)
+	return vm.poolIndex[key]
+}
+
+// truncatePool drops the entries of the pool from `n' on
+func (vm *VM) truncatePool(n int) {
+	for key, idx := range vm.poolIndex {
+		if int(idx) >= n {
+			delete(vm.poolIndex, key)
+		}
+	}
+	vm.pool = vm.pool[:n]
+}
+
+// indexPool works out the lookup for the pool from scratch, as when
+// it has been loaded from an image
+func (vm *VM) indexPool() {
+	vm.poolIndex = make(map[interface{}]uint32, len(vm.pool))
+	// backwards, so the first of any duplicates is the one shared
+	for i := len(vm.pool) - 1; i >= 0; i-- {
+		if shareable(vm.pool[i]) {
+			vm.poolIndex[poolKey(vm.pool[i])] = uint32(i)
+		}
+	}
+}
+
+// (litPOOL) pushes the literal in the pool at the index in the next cell
+func litPOOL(vm *VM) error {
+	vm.ip++
+	i := vm.codeseg[vm.ip]
+	if int(i) >= len(vm.pool) {
+		return &StateError{Word: "(litPOOL)", Reason: fmt.Sprintf("entry %d is past the end of the pool", i)}
+	}
+	vm.Stack = append(vm.Stack, vm.pool[i])
+	return nil
+}
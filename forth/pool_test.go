@@ -0,0 +1,73 @@
+package forth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLiteralPool(t *testing.T) {
+	fvm := NewVM()
+	words, pool := len(fvm.words), len(fvm.pool)
+	tstOutputOf(t, fvm, `: a " hi" 2.5 100000000000 ;
+: b " hi" 2.5 2 ;`)
+
+	// literals don't need words of their own, and are shared
+	if len(fvm.words) != words+2 || len(fvm.pool) != pool+3 {
+		t.Errorf("expected 2 words and 3 literals, got %d and %d", len(fvm.words)-words, len(fvm.pool)-pool)
+	}
+	if see, _ := fvm.See("a"); see != `: a " hi" 2.5 100000000000 ;` {
+		t.Errorf("wrong decompiled code: %s", see)
+	}
+	if out := tstOutputOf(t, fvm, "a . . . b . . ."); out != "100000000000 2.5 hi 2 2.5 hi " {
+		t.Errorf("wrong output: %q", out)
+	}
+
+	// forgotten words give their literals back
+	tstOutputOf(t, fvm, `mark : c " new" " hi" 7.5 ; forget`)
+	if len(fvm.pool) != pool+3 {
+		t.Errorf("pool not reclaimed: %d entries", len(fvm.pool)-pool)
+	}
+	tstOutputOf(t, fvm, `: d 7.5 " hi" ;`)
+	if len(fvm.pool) != pool+4 {
+		t.Errorf("pool not shared after forget: %d entries", len(fvm.pool)-pool)
+	}
+
+	// and the pool is saved in images
+	loaded, err := tstImage(t, fvm, []ImageOption{DropUnreferenced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tstOutputOf(t, loaded, `a . . . d . .`); out != "100000000000 2.5 hi hi 7.5 " {
+		t.Errorf("wrong output from image: %q", out)
+	}
+	tstOutputOf(t, loaded, `: e " hi" 100000000000 ;`)
+	if len(loaded.pool) != len(fvm.pool) {
+		t.Errorf("pool not shared after loading: %d entries, not %d", len(loaded.pool), len(fvm.pool))
+	}
+
+	tstBothForms(t, `: f " x" 1.5 3000000000 3000000000 + ; f`)
+}
+
+func TestPoolSignedZero(t *testing.T) {
+	for _, defs := range []string{`: f -0.0 ; : g 0.0 ;`, `: g 0.0 ; : f -0.0 ;`} {
+		fvm := NewVM()
+		tstOutputOf(t, fvm, defs)
+		loaded, err := tstImage(t, fvm, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []*VM{fvm, loaded} {
+			v.Stack = nil
+			tstOutputOf(t, v, `: h -0.0 0.0 ; f g h`)
+			want := []bool{true, false, true, false}
+			if len(v.Stack) != len(want) {
+				t.Fatalf("%s: wrong stack %v", defs, v.Stack)
+			}
+			for i, neg := range want {
+				if f, ok := v.Stack[i].(float64); !ok || f != 0 || math.Signbit(f) != neg {
+					t.Errorf("%s: item %d is %v", defs, i, v.Stack[i])
+				}
+			}
+		}
+	}
+}
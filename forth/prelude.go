@@ -0,0 +1,53 @@
+package forth
+
+import (
+	"embed"
+	"fmt"
+)
+
+//go:embed prelude/*.fs
+var preludeFS embed.FS
+
+// Prelude selects how much of the wordset written in forth NewVM
+// loads on top of the kernel written in Go.
+type Prelude int
+
+const (
+	// PreludeNone gives just the Go kernel
+	PreludeNone Prelude = iota
+
+	// PreludeCore adds the core wordset, like nip, 2dup, 0=, min, and
+	// spaces.  This is the default.
+	PreludeCore
+
+	// PreludeFull adds conveniences on top of the core wordset
+	PreludeFull
+)
+
+// preludeFiles maps each Prelude to the file to load for it
+var preludeFiles = map[Prelude]string{
+	PreludeCore: "prelude/core.fs",
+	PreludeFull: "prelude/full.fs",
+}
+
+// WithPrelude selects the prelude NewVM loads
+func WithPrelude(p Prelude) Option {
+	return func(vm *VM) {
+		vm.prelude = p
+	}
+}
+
+// loadPrelude loads the prelude the VM was configured with. The
+// prelude is part of the package, so any problem with it is a bug.
+func (vm *VM) loadPrelude() {
+	name, ok := preludeFiles[vm.prelude]
+	if !ok {
+		return
+	}
+	limits := vm.limits
+	vm.SetLimits(Limits{})
+	defer vm.SetLimits(limits)
+	if err := vm.LoadModule(preludeFS, name); err != nil {
+		panic(fmt.Sprintf("forth: bad prelude: %v", FormatError(err)))
+	}
+}
@@ -0,0 +1,35 @@
+\ core.fs -- the core wordset, written in forth on top of the Go kernel.
+vocab core
+
+\ stack words
+: nip   ( a b -- b )  swap drop ;
+: tuck  ( a b -- b a b )  swap over ;
+: 2dup  ( a b -- a b a b )  over over ;
+: 2drop ( a b -- )  drop drop ;
+: 2swap ( a b c d -- c d a b )  rot >r rot r> ;
+: 2over ( a b c d -- a b c d a b )  >r >r 2dup r> r> 2swap ;
+: ?dup  ( a -- a a | 0 )  dup if dup then ;
+
+\ flags and comparisons
+: true  ( -- t )  -1 ;
+: false ( -- f )  0 ;
+: 0=    ( n -- flag )  0 = ;
+: 0<    ( n -- flag )  0 < ;
+: 0>    ( n -- flag )  0 > ;
+: not   ( flag -- flag )  0= ;
+: <>    ( a b -- flag )  = 0= ;
+: <=    ( a b -- flag )  > 0= ;
+: >=    ( a b -- flag )  < 0= ;
+
+\ arithmetic
+: 1+     ( n -- n+1 )  1 + ;
+: 1-     ( n -- n-1 )  1 - ;
+: negate ( n -- -n )  0 swap - ;
+: abs    ( n -- |n| )  dup 0< if negate then ;
+: min    ( a b -- min )  2dup > if swap then drop ;
+: max    ( a b -- max )  2dup < if swap then drop ;
+
+\ output
+: emit   ( c -- )  chr type ;
+: space  ( -- )  32 emit ;
+: spaces ( n -- )  begin dup 0> while space 1- repeat drop ;
@@ -0,0 +1,16 @@
+\ full.fs -- conveniences on top of the core wordset.
+require core.fs
+vocab full
+
+\ arithmetic
+: square ( n -- n*n )  dup * ;
+: sign   ( n -- -1|0|1 )  dup 0< if drop -1 else 0> if 1 else 0 then then ;
+: clamp  ( n lo hi -- n' )  rot min max ;
+: within ( n lo hi -- flag )  >r over r> < >r < 0= r> and ;
+
+\ execution tokens
+: times  ( xt n -- )  begin dup 0> while >r dup >r execute r> r> 1- repeat 2drop ;
+
+\ strings
+: .line   ( x -- )  type cr ;
+: .quoted ( x -- )  34 emit type 34 emit ;
@@ -0,0 +1,34 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPreludeCore(t *testing.T) {
+	tstRunForth(t, `1 2 2dup 2drop  3 ?dup 0 ?dup`, 1, 2, 3, 3, 0)
+	tstRunForth(t, `5 negate abs  3 8 min  3 8 max  2 2 <>  2 3 <=`, 5, 3, 8, 0, -1)
+	tstRunForth(t, `: countdown ( n -- ) begin dup 1- dup 0= until ; 3 countdown`, 3, 2, 1, 0)
+}
+
+func TestPreludeLevels(t *testing.T) {
+	none := NewVM(WithPrelude(PreludeNone))
+	err := none.Run(strings.NewReader(`1 2 nip`), ioutil.Discard)
+	if !errors.Is(err, ErrUnknownWord) {
+		t.Errorf("expected nip to be missing, got %v", err)
+	}
+
+	full := NewVM(WithPrelude(PreludeFull))
+	err = full.Run(strings.NewReader(`: inc 1+ ; 0 ' inc 5 times  7 1 5 clamp  -4 sign  3 1 4 within`), ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{5, 5, -1, -1}
+	for i := range want {
+		if i >= len(full.Stack) || full.Stack[i] != want[i] {
+			t.Fatalf("wrong stack: %v", full.Stack)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package forth
+
+import "strings"
+
+// patch is a change redefine made to a caller of the word it
+// replaced: a call pointed at the new word, or the whole of the
+// caller's code compiled again.  It is kept so that forgetting the
+// new word can put the caller back.
+type patch struct {
+	caller uint32         // the word which was changed
+	off    int            // where the call is, from the start of its code
+	old    uint32         // the word it called before
+	body   *CompositeWord // the code it had before, if it was compiled again
+}
+
+// WithLateBinding makes every definition of a name which is already
+// defined work like `redefine', so the words which call it always
+// call its newest definition.
+func WithLateBinding() Option {
+	return func(vm *VM) {
+		vm.lateBinding = true
+	}
+}
+
+// rebind points every call to the word at `old' at the word at
+// `new' instead, in the code of every word but `new' itself, which
+// can call the word it replaces.  A word which had `old' inlined into
+// it is compiled again from its source, since the copy can't be
+// patched.  A caller gets a warning when the stack effect of the
+// word it calls might have changed.
+func (vm *VM) rebind(old, new uint32, pos Pos) {
+	if old <= lastSpecial {
+		return
+	}
+	name := vm.words[new].name
+	wasIn, wasOut, wasOK := vm.stackEffect(old, 0)
+	nowIn, nowOut, nowOK := vm.stackEffect(new, 0)
+	for i := range vm.words {
+		body := vm.words[i].body
+		if body == nil || uint32(i) == new {
+			continue
+		}
+		var found bool
+		if body.source != nil {
+			found = vm.recompile(uint32(i), old, new)
+		} else {
+			found = vm.patchCalls(uint32(i), old, new)
+		}
+		if !found {
+			continue
+		}
+		switch {
+		case wasOK && nowOK && wasIn == nowIn && wasOut == nowOut:
+		case wasOK && nowOK:
+			vm.warn(pos, "%s calls %s, whose stack effect changed from %s to %s", vm.words[i].name, name,
+				vm.effectText(old, wasIn, wasOut), vm.effectText(new, nowIn, nowOut))
+		default:
+			vm.warn(pos, "%s calls %s, whose stack effect might have changed", vm.words[i].name, name)
+		}
+	}
+}
+
+// postpones tells if the instruction at `i' in `code' is the
+// literal `postpone' compiles for the word at `old', which `compile,'
+// then compiles a call to
+func postpones(code []insn, i int, old uint32) bool {
+	return code[i].op == opLitUINT && code[i].arg == old &&
+		i+1 < len(code) && code[i+1].op == opCompileComma
+}
+
+// patchCalls points the calls to `old' in the code of the word at
+// `caller' at `new', telling if there were any.  That includes the
+// calls it compiles into other words, by postponing `old'.
+func (vm *VM) patchCalls(caller, old, new uint32) bool {
+	body := vm.words[caller].body
+	found := false
+	code := vm.decode(body)
+	for i, in := range code {
+		at := in.pos
+		switch {
+		case in.op == old:
+		case in.op == opTailCall && in.arg == old, postpones(code, i, old):
+			at++
+		default:
+			continue
+		}
+		vm.patches = append(vm.patches, patch{caller: caller, off: at - body.start, old: old})
+		vm.codeseg[at] = new
+		found = true
+	}
+	body.thread = nil
+	return found
+}
+
+// recompile compiles the source of the word at `caller' again, with
+// `new' in place of `old', telling if there was anything to replace.
+// The new code goes at the end of the codeseg, and the old code is
+// left as it is, for anything still running it.
+func (vm *VM) recompile(caller, old, new uint32) bool {
+	body := vm.words[caller].body
+	source := append([]insn(nil), body.source...)
+	found := false
+	for i := range source {
+		switch {
+		case source[i].op == old:
+			source[i].op, found = new, true
+		case postpones(source, i, old):
+			source[i].arg, found = new, true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	code := vm.peephole(append([]insn(nil), source...))
+	vm.tailCalls(code)
+	cw := &CompositeWord{start: len(vm.codeseg), name: body.name}
+	vm.assemble(cw.start, code)
+	cw.end = len(vm.codeseg)
+	if vm.inlines(source) {
+		cw.source = source
+	}
+	vm.patches = append(vm.patches, patch{caller: caller, off: -1, old: old, body: body})
+	vm.setBody(caller, cw)
+	return true
+}
+
+// setBody gives the word at `idx' the code in `cw'.  Threaded code
+// which calls it has the old code, so all of it is threaded again.
+func (vm *VM) setBody(idx uint32, cw *CompositeWord) {
+	vm.words[idx].body, vm.words[idx].Run = cw, cw.Run
+	for _, w := range vm.words {
+		if w.body != nil {
+			w.body.thread = nil
+		}
+	}
+}
+
+// unpatch undoes the patches made since there were `n' of them
+func (vm *VM) unpatch(n int) {
+	for i := len(vm.patches) - 1; i >= n; i-- {
+		p := vm.patches[i]
+		body := vm.words[p.caller].body
+		switch {
+		case p.body != nil:
+			vm.setBody(p.caller, p.body)
+		case body != nil && p.off >= 0:
+			vm.codeseg[body.start+p.off] = p.old
+			body.thread = nil
+		}
+	}
+	vm.patches = vm.patches[:n]
+}
+
+// arity counts the inputs and outputs of a stack effect like
+// "( a b -- c )"
+func arity(effect string) (in, out int, ok bool) {
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(effect, "("), ")"))
+	for i, f := range fields {
+		if f == "--" {
+			return i, len(fields) - i - 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// maxEffectDepth is how deeply stackEffect looks through calls
+const maxEffectDepth = 8
+
+// stackEffect works out how many items the word at `idx' takes and
+// leaves: from its declared effect, or else from its code, as long
+// as that runs straight through and everything it calls has an
+// effect too.
+func (vm *VM) stackEffect(idx uint32, depth int) (in, out int, ok bool) {
+	w := &vm.words[idx]
+	switch {
+	case w.Effect != "":
+		return arity(w.Effect)
+	case w.isLit:
+		return 0, 1, true
+	case w.body == nil || depth >= maxEffectDepth:
+		return 0, 0, false
+	}
+
+	n, low := 0, 0 // the depth of the stack, from where it started
+	for _, c := range vm.decode(w.body) {
+		var takes, leaves int
+		switch {
+		case c.op == opReturn:
+			return -low, n - low, true
+		case c.op == opLitINT || c.op == opLitUINT || c.op == opLitPOOL:
+			leaves, ok = 1, true
+		case c.op == opTailCall:
+			takes, leaves, ok = vm.stackEffect(c.arg, depth+1)
+		case c.op <= lastSpecial:
+			return 0, 0, false
+		default:
+			takes, leaves, ok = vm.stackEffect(c.op, depth+1)
+		}
+		if !ok {
+			return 0, 0, false
+		}
+		if n -= takes; n < low {
+			low = n
+		}
+		n += leaves
+		if c.op == opTailCall {
+			return -low, n - low, true
+		}
+	}
+	return 0, 0, false
+}
+
+// effectText gives the stack effect of the word at `idx' for
+// messages, making one up from the counts if it has none
+func (vm *VM) effectText(idx uint32, in, out int) string {
+	if effect := vm.words[idx].Effect; effect != "" {
+		return effect
+	}
+	return "( " + strings.Repeat("x ", in) + "-- " + strings.Repeat("x ", out) + ")"
+}
+
+// redefine ( "name" -- ) defines a word like `:', but when the
+// definition is finished, every word which calls the old definition
+// calls the new one instead.  Execution tokens of it already on the
+// stack or in variables still refer to the old one.
+func redefine(vm *VM) error {
+	return beginDefinition(vm, "redefine", true)
+}
+
+func rebindWordsInit(vm *VM) {
+	vm.Define("redefine", Word{Run: redefine, InterpretOnly: true, Effect: "( \"name\" -- )", Doc: "redefines a word, and its callers with it"})
+}
@@ -0,0 +1,130 @@
+package forth
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRedefine(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithThreadedCode()}, {WithoutPeephole()}} {
+		fvm := NewVM(opts...)
+		var errs bytes.Buffer
+		fvm.SetErrOutput(&errs)
+		tstOutputOf(t, fvm, `: helper ( n -- n ) dup if 1 + then ; : caller helper . ; : last 5 helper ;
+1 caller mark`)
+		if out := tstOutputOf(t, fvm, `redefine helper ( n -- n ) 2 + ;
+1 caller last .`); out != "3 7 " {
+			t.Errorf("wrong output after redefine: %q", out)
+		}
+		// the new definition can call the one it replaces
+		if out := tstOutputOf(t, fvm, `redefine helper ( n -- n ) helper 10 * ; 1 caller`); out != "30 " {
+			t.Errorf("wrong output from a wrapper: %q", out)
+		}
+		if errs.Len() != 0 {
+			t.Errorf("unexpected warnings: %q", errs.String())
+		}
+		if out := tstOutputOf(t, fvm, `forget 1 caller last .`); out != "2 6 " {
+			t.Errorf("forget didn't restore the callers: %q", out)
+		}
+	}
+}
+
+func TestRedefineInlined(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithThreadedCode()}, {WithoutPeephole()}} {
+		fvm := NewVM(opts...)
+		tstOutputOf(t, fvm, `: one 1 ; : sum one one + ; : five 1 2 3 4 5 + + + + ; : twice five 2 * ;
+sum . twice . mark`)
+		if out := tstOutputOf(t, fvm, `redefine one 10 ; redefine five 5 ; sum . twice .`); out != "20 10 " {
+			t.Errorf("wrong output after redefine: %q", out)
+		}
+		// a caller compiled again keeps calls which were redefined before
+		tstOutputOf(t, fvm, `: branchy dup if then ; : both one branchy ;
+redefine branchy 7 + ; redefine one 3 ;`)
+		if out := tstOutputOf(t, fvm, `both .`); out != "10 " {
+			t.Errorf("wrong output from a caller redefined twice: %q", out)
+		}
+
+		// the code the callers had before survives an image
+		loaded, err := tstImage(t, fvm, nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []*VM{fvm, loaded} {
+			if out := tstOutputOf(t, v, `forget sum . twice .`); out != "2 30 " {
+				t.Errorf("forget didn't restore the callers: %q", out)
+			}
+			if err := v.Verify(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+func TestRedefinePostponed(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithThreadedCode()}, {WithoutPeephole()}} {
+		fvm := NewVM(opts...)
+		fvm.SetErrOutput(ioutil.Discard)
+		tstOutputOf(t, fvm, `: greet 1 ; : [greet] postpone greet ; immediate
+: one 1 ; : [greet2] one drop postpone greet ; immediate mark`)
+
+		// words compiled after the redefine, by an immediate word
+		// which postpones the old one, call the new one
+		if out := tstOutputOf(t, fvm, `redefine greet 2 ; : user [greet] [greet2] ; user . .`); out != "2 2 " {
+			t.Errorf("wrong output after redefine: %q", out)
+		}
+		if out := tstOutputOf(t, fvm, `forget : user [greet] [greet2] ; user . .`); out != "1 1 " {
+			t.Errorf("forget didn't restore the postponed word: %q", out)
+		}
+	}
+}
+
+func TestRedefineWarnings(t *testing.T) {
+	fvm := NewVM()
+	var errs bytes.Buffer
+	fvm.SetErrOutput(&errs)
+	tstOutputOf(t, fvm, `: h ( a -- b ) dup if then ; : one h ; : two h h ; : other ;
+redefine h ( a b -- c ) + ;
+redefine h 1 2 ;
+redefine h dup if then ;
+: short 1 ; : uses short ;
+redefine short 2 ;`)
+	want := `<input>:2:10: warning: one calls h, whose stack effect changed from ( a -- b ) to ( a b -- c )
+<input>:2:10: warning: two calls h, whose stack effect changed from ( a -- b ) to ( a b -- c )
+<input>:3:10: warning: one calls h, whose stack effect changed from ( a b -- c ) to ( -- x x )
+<input>:3:10: warning: two calls h, whose stack effect changed from ( a b -- c ) to ( -- x x )
+<input>:4:10: warning: one calls h, whose stack effect might have changed
+<input>:4:10: warning: two calls h, whose stack effect might have changed
+`
+	if errs.String() != want {
+		t.Errorf("wrong warnings: %q", errs.String())
+	}
+
+	err := fvm.Run(strings.NewReader("redefine nothing-here ;"), ioutil.Discard)
+	if !errors.Is(err, ErrUnknownWord) {
+		t.Errorf("expected an unknown word, got %v", err)
+	}
+}
+
+func TestLateBinding(t *testing.T) {
+	code := `: a 1 ; : b a . ; : a 2 ; b`
+	if out := tstOutputOf(t, NewVM(), code); out != "1 " {
+		t.Errorf("wrong output with early binding: %q", out)
+	}
+	fvm := NewVM(WithLateBinding())
+	if out := tstOutputOf(t, fvm, code); out != "2 " {
+		t.Errorf("wrong output with late binding: %q", out)
+	}
+
+	// the patches survive an image, so forget still undoes them
+	tstOutputOf(t, fvm, `mark : a 3 ;`)
+	loaded, err := tstImage(t, fvm, []ImageOption{DropUnreferenced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tstOutputOf(t, loaded, `b forget b`); out != "3 2 " {
+		t.Errorf("wrong output from the image: %q", out)
+	}
+}
@@ -0,0 +1,198 @@
+package forth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// insn is one decoded instruction from the codeseg: an opcode
+// and its operand, if it has one.
+type insn struct {
+	pos int
+	op  uint32
+	arg uint32
+
+	// for rewriting code: where a branch goes, as an index into the
+	// instructions, and where the instruction came from in the source
+	target int
+	src    Pos
+}
+
+// decode splits the code of a composite word into instructions
+func (vm *VM) decode(cw *CompositeWord) []insn {
+	var code []insn
+	for p := cw.start; p < cw.end; {
+		in := insn{pos: p, op: vm.codeseg[p]}
+		n := operands(in.op)
+		if n > 0 && p+1 < cw.end {
+			in.arg = vm.codeseg[p+1]
+		}
+		code = append(code, in)
+		p += 1 + n
+	}
+	return code
+}
+
+// literalText writes a value the way it would be written in
+// forth source, so reading it back gives the same value.
+func literalText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return `" ` + val + `"`
+	case float64:
+		str := strconv.FormatFloat(val, 'g', -1, 64)
+		if !strings.ContainsAny(str, ".eEIN") {
+			str += ".0"
+		}
+		return str
+	}
+	return fmt.Sprint(v)
+}
+
+// decompile renders the code of a composite word back into forth.
+// Branches are turned back into the control structures that made
+// them, and literal pushers into the literals.
+func (vm *VM) decompile(w Word) string {
+	cw := w.body
+	code := vm.decode(cw)
+	at := make(map[int]int, len(code)) // codeseg address -> index in code
+	for i, in := range code {
+		at[in.pos] = i
+	}
+	isOp := func(pos int, op uint32) bool {
+		i, ok := at[pos]
+		return ok && code[i].op == op
+	}
+
+	// a forward (bzr) which lands just past a backward branch is a
+	// WHILE, and that branch is its REPEAT.
+	whileEnds := make(map[int]bool)
+	for _, in := range code {
+		t := branchTarget(in.pos, in.arg)
+		if in.op == opBZR && t > in.pos && isOp(t-2, opBranch) && branchTarget(t-2, vm.codeseg[t-1]) < t-2 {
+			whileEnds[t] = true
+		}
+	}
+
+	// the other backward branches go to a BEGIN, except for the ends
+	// of DO loops, and a plain branch to the start, which is a RECUR.
+	begins := make(map[int]bool)
+	for i, in := range code {
+		t := branchTarget(in.pos, in.arg)
+		switch {
+		case in.op != opBranch && in.op != opBZR, t > in.pos:
+		case i > 0 && code[i-1].op == opPerfLoopPlus:
+		case in.op == opBZR || whileEnds[in.pos+2] || t != cw.start:
+			begins[t] = true
+		}
+	}
+
+	var out []string
+	emit := func(s ...string) { out = append(out, s...) }
+	emit(":", cw.name)
+	if w.Effect != "" {
+		emit(w.Effect)
+	}
+
+	pending := make(map[int]int) // addresses where IFs and ELSEs land
+	for i := 0; i < len(code); i++ {
+		in := code[i]
+		for ; pending[in.pos] > 0; pending[in.pos]-- {
+			emit("then")
+		}
+		if begins[in.pos] {
+			emit("begin")
+		}
+
+		switch t := branchTarget(in.pos, in.arg); in.op {
+		case opReturn:
+			if in.pos == cw.end-1 {
+				emit(";")
+			} else {
+				emit("exit")
+			}
+		case opLitINT, opLitUINT:
+			n := int(in.arg)
+			if in.op == opLitINT {
+				n = int(int32(in.arg))
+			}
+			if i+1 < len(code) && code[i+1].op == opCompileComma && n >= 0 && n < len(vm.words) {
+				emit("postpone", vm.wordName(uint32(n)))
+				i++
+			} else {
+				emit(strconv.Itoa(n))
+			}
+		case opBZR:
+			switch {
+			case t <= in.pos:
+				emit("until")
+			case whileEnds[t]:
+				emit("while")
+			default:
+				emit("if")
+				pending[t]++
+			}
+		case opBranch:
+			switch {
+			case t > in.pos && pending[in.pos+2] > 0:
+				emit("else")
+				pending[in.pos+2]--
+				pending[t]++
+			case t > in.pos:
+				emit("(branch)", strconv.Itoa(int(int32(in.arg))))
+			case whileEnds[in.pos+2]:
+				emit("repeat")
+			case t == cw.start && !begins[t]:
+				emit("recur")
+			default:
+				emit("again")
+			}
+		case opTailCall:
+			emit(vm.wordName(in.arg))
+		case opLitPOOL:
+			emit(literalText(vm.pool[in.arg]))
+		case opSetupDo:
+			emit("do")
+			if i+1 < len(code) && code[i+1].op == opTestDo {
+				i++
+			}
+		case opPerfLoopPlus:
+			emit("+loop")
+			i += vm.skipLoopEnd(code[i+1:])
+		default:
+			word := vm.words[in.op]
+			switch {
+			case word.name == "r@" && i+1 < len(code) && code[i+1].op == opPerfLoopPlus:
+				emit("loop")
+				i++
+				i += vm.skipLoopEnd(code[i+1:])
+			case word.isLit:
+				emit(literalText(word.lit))
+			case word.Immediate:
+				emit("postpone", vm.wordName(in.op))
+			default:
+				emit(vm.wordName(in.op))
+			}
+		}
+	}
+
+	if w.Immediate {
+		emit("immediate")
+	}
+	return strings.Join(out, " ")
+}
+
+// skipLoopEnd counts the instructions that finish a DO loop after
+// its (perfLoopPlus): the branch back, and dropping the loop
+// parameters.
+func (vm *VM) skipLoopEnd(code []insn) int {
+	n := 0
+	if n < len(code) && code[n].op == opBranch {
+		n++
+	}
+	for n < len(code) && n < 4 && vm.words[code[n].op].name == "rdrop" {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package forth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pos is a location in a source of forth code.
+type Pos struct {
+	Source string // the name of the input
+	Line   int    // 1-based line number
+	Col    int    // 1-based column, counted in runes
+
+	src *source // where to find the text of the line, if we still can
+}
+
+// IsValid reports whether the position refers to real input.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String gives the position as `source:line:col'
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Source, p.Line, p.Col)
+}
+
+// text tries to find the full text of the line the position is on.
+func (p Pos) text() (string, bool) {
+	if p.src == nil || !p.IsValid() {
+		return "", false
+	}
+	return p.src.text(p.Line)
+}
+
+// keptLines is how many of the lines already read a source holds on
+// to, for errors to show
+const keptLines = 32
+
+// source is an input stream for the interpreter. It keeps track of
+// the line and column of everything it reads, and holds on to the
+// last few lines it has seen so errors can show them later.
+type source struct {
+	r    *bufio.Reader
+	name string
+
+	line, col int // position of the next rune
+	last      Pos // position of the most recently read rune
+
+	cur   []rune   // the current line, so far
+	lines []string // the last keptLines completed lines
+	first int      // the line number of lines[0]
+	eof   bool     // have we read everything?
+
+	file *module // where the source came from, if it is a file
+}
+
+// newSource wraps a reader for the interpreter. If the reader
+// can name itself (like an *os.File), that name is used for
+// positions. Otherwise, it is called `<input>'.
+func newSource(r io.Reader) *source {
+	name := "<input>"
+	if n, ok := r.(interface{ Name() string }); ok {
+		name = n.Name()
+	}
+	return newNamedSource(r, name)
+}
+
+// newNamedSource wraps a reader for the interpreter, with
+// the given name for positions.
+func newNamedSource(r io.Reader, name string) *source {
+	return &source{r: bufio.NewReader(r), name: name, line: 1, col: 1, first: 1}
+}
+
+// ReadRune reads the next rune, updating the line and column
+// as it goes.
+func (s *source) ReadRune() (ch rune, sz int, err error) {
+	ch, sz, err = s.r.ReadRune()
+	if err != nil {
+		s.eof = (err == io.EOF)
+		return
+	}
+	s.last = Pos{Source: s.name, Line: s.line, Col: s.col, src: s}
+	if ch == '\n' {
+		s.lines = append(s.lines, string(s.cur))
+		if len(s.lines) > keptLines {
+			s.lines = s.lines[1:]
+			s.first++
+		}
+		s.cur = s.cur[:0]
+		s.line++
+		s.col = 1
+	} else {
+		s.cur = append(s.cur, ch)
+		s.col++
+	}
+	return
+}
+
+// text gives the text of a line, if we have seen it recently. For
+// the line currently being read, whatever is already buffered is
+// used to fill out the rest of the line, without consuming any input.
+func (s *source) text(line int) (string, bool) {
+	switch {
+	case line < s.first || line > s.line:
+		return "", false
+	case line < s.first+len(s.lines):
+		return strings.TrimRight(s.lines[line-s.first], "\r"), true
+	}
+
+	var sb strings.Builder
+	sb.WriteString(string(s.cur))
+	rest, _ := s.r.Peek(s.r.Buffered())
+	for len(rest) > 0 {
+		ch, sz := utf8.DecodeRune(rest)
+		if ch == '\n' {
+			break
+		}
+		sb.WriteRune(ch)
+		rest = rest[sz:]
+	}
+	return strings.TrimRight(sb.String(), "\r"), true
+}
@@ -8,7 +8,7 @@ func dup(vm *VM) (e error) {
 	if top >= 1 {
 		vm.Stack = append(vm.Stack, vm.Stack[top-1])
 	} else {
-		e = ErrUnderflow
+		e = vm.underflow("dup", 1)
 	}
 	return
 }
@@ -19,7 +19,7 @@ func over(vm *VM) (e error) {
 	if top >= 2 {
 		vm.Stack = append(vm.Stack, vm.Stack[top-2])
 	} else {
-		e = ErrUnderflow
+		e = vm.underflow("over", 2)
 	}
 	return
 }
@@ -30,7 +30,7 @@ func drop(vm *VM) (e error) {
 	if top >= 1 {
 		vm.Stack = vm.Stack[:top-1]
 	} else {
-		e = ErrUnderflow
+		e = vm.underflow("drop", 1)
 	}
 	return
 }
@@ -41,7 +41,7 @@ func swap(vm *VM) (e error) {
 	if top >= 2 {
 		vm.Stack[top-1], vm.Stack[top-2] = vm.Stack[top-2], vm.Stack[top-1]
 	} else {
-		e = ErrUnderflow
+		e = vm.underflow("swap", 2)
 	}
 	return
 }
@@ -53,7 +53,7 @@ func rotate(vm *VM) (e error) {
 		vm.Stack[top-1], vm.Stack[top-2], vm.Stack[top-3] =
 			vm.Stack[top-3], vm.Stack[top-1], vm.Stack[top-2]
 	} else {
-		e = ErrUnderflow
+		e = vm.underflow("rot", 3)
 	}
 	return
 }
@@ -65,31 +65,7 @@ func minusRotate(vm *VM) (e error) {
 		vm.Stack[top-1], vm.Stack[top-2], vm.Stack[top-3] =
 			vm.Stack[top-2], vm.Stack[top-3], vm.Stack[top-1]
 	} else {
-		e = ErrUnderflow
-	}
-	return
-}
-
-// : nip swap drop ;
-func nip(vm *VM) (e error) {
-	top := len(vm.Stack)
-	if top >= 2 {
-		vm.Stack[top-2] = vm.Stack[top-1]
-		vm.Stack = vm.Stack[:top-1]
-	} else {
-		e = ErrUnderflow
-	}
-	return
-}
-
-// : tuck swap over ;
-func tuck(vm *VM) (e error) {
-	top := len(vm.Stack)
-	if top >= 2 {
-		vm.Stack = append(vm.Stack, vm.Stack[top-1])
-		vm.Stack[top-1], vm.Stack[top-2] = vm.Stack[top-2], vm.Stack[top-1]
-	} else {
-		e = ErrUnderflow
+		e = vm.underflow("-rot", 3)
 	}
 	return
 }
@@ -97,16 +73,18 @@ func tuck(vm *VM) (e error) {
 // >r push onto rstack
 func toR(vm *VM) (e error) {
 	var tos interface{}
-	tos, e = vm.Pop()
-	vm.RPush(tos)
+	if tos, e = vm.pop(">r"); e == nil {
+		vm.RPush(tos)
+	}
 	return
 }
 
 // r> pop from rstack
 func fromR(vm *VM) (e error) {
 	var tos interface{}
-	tos, e = vm.RPop()
-	vm.Push(tos)
+	if tos, e = vm.rpop("r>"); e == nil {
+		vm.Push(tos)
+	}
 	return
 }
 
@@ -114,29 +92,27 @@ func fromR(vm *VM) (e error) {
 func peekR(vm *VM) error {
 	tos := len(vm.Rstack) - 1
 	if tos < 0 {
-		return ErrRStackUnderflow
+		return vm.rUnderflow("r@", 1)
 	}
 	vm.Push(vm.Rstack[tos])
 	return nil
 }
 
 func rdrop(vm *VM) error {
-	_, err := vm.RPop()
+	_, err := vm.rpop("rdrop")
 	return err
 }
 
 // stackWordsInit adds stack-related core words to the VM
 func stackWordsInit(vm *VM) {
-	vm.Define("dup", Word{dup, false})
-	vm.Define("drop", Word{drop, false})
-	vm.Define("swap", Word{swap, false})
-	vm.Define("over", Word{over, false})
-	vm.Define("rot", Word{rotate, false})
-	vm.Define("-rot", Word{minusRotate, false})
-	vm.Define("nip", Word{nip, false})
-	vm.Define("tuck", Word{tuck, false})
-	vm.Define(">r", Word{toR, false})
-	vm.Define("r>", Word{fromR, false})
-	vm.Define("r@", Word{peekR, false})
-	vm.Define("rdrop", Word{rdrop, false})
+	vm.Define("dup", Word{Run: dup, Effect: "( a -- a a )", Doc: "duplicates the top of the stack"})
+	vm.Define("drop", Word{Run: drop, Effect: "( a -- )", Doc: "removes the top of the stack"})
+	vm.Define("swap", Word{Run: swap, Effect: "( a b -- b a )", Doc: "exchanges the top two items"})
+	vm.Define("over", Word{Run: over, Effect: "( a b -- a b a )", Doc: "copies the second item to the top"})
+	vm.Define("rot", Word{Run: rotate, Effect: "( a b c -- b c a )", Doc: "rotates the third item to the top"})
+	vm.Define("-rot", Word{Run: minusRotate, Effect: "( a b c -- c a b )", Doc: "rotates the top item to third"})
+	vm.Define(">r", Word{Run: toR, Effect: "( x -- ) ( R: -- x )", Doc: "moves the top of the stack to the r-stack"})
+	vm.Define("r>", Word{Run: fromR, Effect: "( -- x ) ( R: x -- )", Doc: "moves the top of the r-stack to the stack"})
+	vm.Define("r@", Word{Run: peekR, Effect: "( -- x ) ( R: x -- x )", Doc: "copies the top of the r-stack to the stack"})
+	vm.Define("rdrop", Word{Run: rdrop, Effect: "( R: x -- )", Doc: "removes the top of the r-stack"})
 }
@@ -1,6 +1,7 @@
 package forth
 
 import (
+	"errors"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -56,7 +57,7 @@ func TestSwap(t *testing.T) {
 
 func TestOver(t *testing.T) {
 	tstRunForth(t, `2 3 OVER `, 2, 3, 2)
-	if e := tstRunForthErr(t, `over`); e != ErrUnderflow {
+	if e := tstRunForthErr(t, `over`); !errors.Is(e, ErrUnderflow) {
 		t.Error(e)
 	}
 }
@@ -72,13 +73,13 @@ func TestTuck(t *testing.T) {
 
 func TestDrop(t *testing.T) {
 	tstRunForth(t, `2 3 drop`, 2)
-	if e := tstRunForthErr(t, `drop drop`); e != ErrUnderflow {
+	if e := tstRunForthErr(t, `drop drop`); !errors.Is(e, ErrUnderflow) {
 		t.Error(e)
 	}
 }
 
 func TestRot(t *testing.T) {
-	if e := tstRunForthErr(t, `3 2  rot`, 3, 2); e != ErrUnderflow {
+	if e := tstRunForthErr(t, `3 2  rot`, 3, 2); !errors.Is(e, ErrUnderflow) {
 		t.Error(e)
 	}
 	tstRunForth(t, ` 2 3 4 rot `, 3, 4, 2)
@@ -0,0 +1,229 @@
+package forth
+
+// In threaded form, a composite word runs from a slice of cells
+// worked out from its code the first time it runs, rather than from
+// the codeseg.  Each cell has what the instruction there needs ready
+// to go: the word it calls, or a closure which does the work, with
+// the literals and branch targets built in.  The codeseg stays the
+// real code, for `see', images and the verifier.
+
+// the kinds of cell in a threaded word
+const (
+	cellWord   = iota // run a word through dispatch
+	cellFast          // run a closure, unless metering or recovering
+	cellCall          // call a composite word
+	cellTail          // tail-call a composite word
+	cellReturn        // return
+)
+
+// cell is one instruction of a threaded word.  Cells for operands
+// are never run, unless something jumps to them, in which case they
+// act just like the codeseg would.
+type cell struct {
+	kind int
+	idx  uint32         // the word the instruction is for
+	body *CompositeWord // the word to call
+	fast func(*VM) error
+}
+
+// WithThreadedCode makes the VM run composite words in threaded
+// form, which is faster, but uses more memory
+func WithThreadedCode() Option {
+	return func(vm *VM) {
+		vm.threaded = true
+	}
+}
+
+// thread works out the cells for a composite word
+func (vm *VM) thread(c *CompositeWord) []cell {
+	cells := make([]cell, c.end-c.start)
+	operand := false // is the next cell an operand, rather than a word?
+	for p := c.start; p < c.end; p++ {
+		idx := vm.codeseg[p]
+		cl := cell{kind: cellWord, idx: idx}
+		var arg uint32
+		if p+1 < len(vm.codeseg) {
+			arg = vm.codeseg[p+1]
+		}
+		isOperand := operand
+		operand = !isOperand && operands(idx) > 0
+		switch {
+		case isOperand || int(idx) >= len(vm.words):
+			// an operand isn't run, and anything else is left
+			// to dispatch to fail
+		case idx == opReturn:
+			cl.kind = cellReturn
+		case idx == opTailCall && int(arg) < len(vm.words) && vm.words[arg].body != nil:
+			cl.kind, cl.body = cellTail, vm.words[arg].body
+		case vm.words[idx].body != nil:
+			cl.kind, cl.body = cellCall, vm.words[idx].body
+		case vm.words[idx].name == "execute" && vm.words[idx].Vocab == "kernel":
+			// it can call a word, which changes the word running
+		default:
+			cl.kind, cl.fast = cellFast, vm.fastCode(idx, arg, p)
+		}
+		cells[p-c.start] = cl
+	}
+	return cells
+}
+
+// fastCode gives the closure to run for the word `idx', at `p' in
+// the codeseg with `arg' after it.  That's the word itself, unless
+// there is a faster way.
+func (vm *VM) fastCode(idx, arg uint32, p int) func(*VM) error {
+	switch idx {
+	case opLitINT, opLitUINT:
+		// box the number once, rather than every time it's pushed
+		var n interface{} = int(arg)
+		if idx == opLitINT {
+			n = int(int32(arg))
+		}
+		return func(vm *VM) error {
+			vm.Stack = append(vm.Stack, n)
+			vm.ip++
+			return nil
+		}
+	case opLitPOOL:
+		if int(arg) >= len(vm.pool) {
+			break // leave it to (litPOOL) to fail
+		}
+		v := vm.pool[arg]
+		return func(vm *VM) error {
+			vm.Stack = append(vm.Stack, v)
+			vm.ip++
+			return nil
+		}
+	case opBranch:
+		to := branchTarget(p, arg) - 1
+		return func(vm *VM) error {
+			vm.ip = to
+			return nil
+		}
+	case opBZR:
+		to := branchTarget(p, arg) - 1
+		return func(vm *VM) error {
+			if l := len(vm.Stack) - 1; l >= 0 {
+				if n, ok := vm.Stack[l].(int); ok {
+					vm.Stack = vm.Stack[:l]
+					if n == 0 {
+						vm.ip = to
+					} else {
+						vm.ip++
+					}
+					return nil
+				}
+			}
+			return branchZero(vm)
+		}
+	}
+
+	w := &vm.words[idx]
+	if w.isLit {
+		v := w.lit
+		return func(vm *VM) error {
+			vm.Stack = append(vm.Stack, v)
+			return nil
+		}
+	}
+	if op, ok := fastInts[w.name]; ok && w.Vocab == "kernel" {
+		slow := w.Run
+		return func(vm *VM) error {
+			if l := len(vm.Stack); l >= 2 {
+				if a, ok := vm.Stack[l-2].(int); ok {
+					if b, ok := vm.Stack[l-1].(int); ok {
+						vm.Stack[l-2] = op(a, b)
+						vm.Stack = vm.Stack[:l-1]
+						return nil
+					}
+				}
+			}
+			return slow(vm)
+		}
+	}
+	return w.Run
+}
+
+// fastInts are the kernel words which get a fast path for two ints
+var fastInts = map[string]func(a, b int) int{
+	"+":   func(a, b int) int { return a + b },
+	"-":   func(a, b int) int { return a - b },
+	"*":   func(a, b int) int { return a * b },
+	"and": func(a, b int) int { return a & b },
+	"or":  func(a, b int) int { return a | b },
+	"xor": func(a, b int) int { return a ^ b },
+	"=":   func(a, b int) int { return flag(a == b) },
+	"<":   func(a, b int) int { return flag(a < b) },
+	">":   func(a, b int) int { return flag(a > b) },
+}
+
+// runThreaded is the inner interpreter for threaded code, which
+// runs until the calls go back down to `base'
+func (vm *VM) runThreaded(base int) error {
+	c := vm.threadTop()
+	for {
+		here := vm.ip
+		off := here - c.start
+		if off < 0 || off >= len(c.thread) {
+			err := &StateError{Word: c.name, Reason: "ran outside of its code"}
+			return vm.unwind(base, err, opReturn, here)
+		}
+
+		cl := &c.thread[off]
+		var err error
+		switch cl.kind {
+		case cellFast:
+			if vm.metering || vm.recoverPanics {
+				err = vm.dispatch(cl.idx, true)
+			} else {
+				vm.inner = true
+				err = cl.fast(vm)
+			}
+			if err == nil {
+				err = vm.step()
+			}
+		case cellReturn:
+			var done bool
+			if done, err = vm.leave(base); done {
+				return err
+			}
+			c = vm.threadTop()
+		case cellTail:
+			callee := vm.codeseg[here+1]
+			if err = vm.tailEnter(callee); err != nil {
+				return vm.unwind(base, err, callee, here)
+			}
+			c = vm.threadTop()
+			continue
+		case cellCall:
+			if vm.metering {
+				err = vm.meter(cl.idx)
+			}
+			if err == nil {
+				err = vm.enter(cl.body)
+			}
+			if err == nil {
+				c = vm.threadTop()
+				continue
+			}
+		default:
+			if err = vm.dispatch(cl.idx, true); err == nil {
+				err = vm.step()
+			}
+			c = vm.threadTop()
+		}
+		if err != nil {
+			return vm.unwind(base, err, cl.idx, here)
+		}
+		vm.ip++
+	}
+}
+
+// threadTop gives the running composite word, threading it if it
+// hasn't been yet
+func (vm *VM) threadTop() *CompositeWord {
+	c := vm.calls[len(vm.calls)-1].word
+	if c.thread == nil {
+		c.thread = vm.thread(c)
+	}
+	return c
+}
@@ -0,0 +1,85 @@
+package forth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// the examples from the README
+const (
+	tstBlocks = `: block ( size -- )
+  0 swap tuck 0 DO over over DO j type i .  LOOP cr LOOP
+  drop drop ;
+: blocks ( num -- ) 1 + 1 DO i block loop ;
+`
+	tstRegreet = `: regreet ( num -- ) " HELLO! " . -1 + dup IF recur ELSE drop THEN ;
+`
+	tstSum = `: sum ( n -- total ) 0 swap 0 do i 3 * 1 - + i 2 mod if 1 + then loop ;
+`
+)
+
+// tstBothForms runs code in a VM with and without threaded code,
+// checking they agree, and gives back the output
+func tstBothForms(t *testing.T, code string) string {
+	t.Helper()
+	var results [2]string
+	for i, opts := range [][]Option{nil, {WithThreadedCode()}} {
+		fvm := NewVM(opts...)
+		var out bytes.Buffer
+		err := fvm.Run(strings.NewReader(code), &out)
+		results[i] = fmt.Sprintf("%q %v %v", out.String(), fvm.Stack, err)
+	}
+	if results[0] != results[1] {
+		t.Errorf("threaded code differs:\n%s\n%s", results[0], results[1])
+	}
+	return results[0]
+}
+
+func TestThreadedCode(t *testing.T) {
+	tstBothForms(t, tstBlocks+"4 blocks")
+	tstBothForms(t, tstRegreet+"5 regreet")
+	tstBothForms(t, tstSum+"1000 sum")
+	tstBothForms(t, `: f 1 2 + " a" " b" + 2.5 3 * 7 5 > 70000 ; f`)
+	tstBothForms(t, `: g begin 1 - dup while dup . repeat ; : h 5 g ; h`)
+	tstBothForms(t, `: sq dup * ; : run ['] sq execute ; 4 run`)
+	tstBothForms(t, `: bad 1 " x" + ; : outer bad ; outer`)
+	tstBothForms(t, `: bad2 " x" if 1 then ; bad2`)
+	tstBothForms(t, `: ops 4 * 10 * ; 2 ops`) // operands which look like opcodes
+}
+
+// benchForms runs the same code in each form
+func benchForms(b *testing.B, defs, code string) {
+	for _, form := range []struct {
+		name string
+		opts []Option
+	}{{"switch", nil}, {"threaded", []Option{WithThreadedCode()}}} {
+		b.Run(form.name, func(b *testing.B) {
+			fvm := NewVM(form.opts...)
+			if err := fvm.Run(strings.NewReader(defs), ioutil.Discard); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fvm.ResetState()
+				if err := fvm.Run(strings.NewReader(code), ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBlocks(b *testing.B) {
+	benchForms(b, tstBlocks, "12 blocks")
+}
+
+func BenchmarkRegreet(b *testing.B) {
+	benchForms(b, tstRegreet, "500 regreet")
+}
+
+func BenchmarkSum(b *testing.B) {
+	benchForms(b, tstSum, "10000 sum drop")
+}
@@ -0,0 +1,87 @@
+package forth
+
+import "fmt"
+
+// isBranch tells if `op' jumps by the relative amount in its operand
+func isBranch(op uint32) bool {
+	switch op {
+	case opBranch, opBZR, opTestDo:
+		return true
+	}
+	return false
+}
+
+// verifyWord checks that the code of a composite word is safe to
+// run: every cell is an opcode for a real word or an operand of
+// one, branches land on an opcode inside the word, and the code
+// ends with a (RET).
+func (vm *VM) verifyWord(cw *CompositeWord) error {
+	fail := func(pos int, format string, args ...interface{}) error {
+		return &VerifyError{Word: cw.name, Offset: pos - cw.start, Reason: fmt.Sprintf(format, args...)}
+	}
+	if cw.start < 0 || cw.end > len(vm.codeseg) || cw.start >= cw.end {
+		return fail(cw.start, "code at %d..%d is outside the codeseg", cw.start, cw.end)
+	}
+
+	opcodes := make(map[int]bool)
+	var branches []int
+	last := -1
+	for p := cw.start; p < cw.end; {
+		op := vm.codeseg[p]
+		if int(op) >= len(vm.words) {
+			return fail(p, "%d is not a word", op)
+		}
+		if op != opReturn && vm.words[op].Run == nil {
+			return fail(p, "%s can't be run", vm.wordName(op))
+		}
+		n := operands(op)
+		if p+n >= cw.end {
+			return fail(p, "%s is missing its operand", vm.wordName(op))
+		}
+		if isBranch(op) {
+			branches = append(branches, p)
+		}
+		if op == opLitPOOL && int(vm.codeseg[p+1]) >= len(vm.pool) {
+			return fail(p, "(litPOOL) of entry %d, past the end of the pool", vm.codeseg[p+1])
+		}
+		if op == opTailCall {
+			if callee := vm.codeseg[p+1]; int(callee) >= len(vm.words) || vm.words[callee].body == nil {
+				return fail(p, "(tailcall) of %s, which isn't a composite word", vm.wordName(callee))
+			}
+		}
+		opcodes[p], last = true, p
+		p += 1 + n
+	}
+
+	if vm.codeseg[last] != opReturn {
+		return fail(last, "the code does not end with (RET)")
+	}
+	for _, p := range branches {
+		t := branchTarget(p, vm.codeseg[p+1])
+		if t < cw.start || t >= cw.end {
+			return fail(p, "%s goes to %d, outside the word", vm.wordName(vm.codeseg[p]), t-cw.start)
+		}
+		if !opcodes[t] {
+			return fail(p, "%s goes to %d, which is an operand", vm.wordName(vm.codeseg[p]), t-cw.start)
+		}
+	}
+	return nil
+}
+
+// Verify checks the code of every word written in forth, giving a
+// *VerifyError for the first one which isn't safe to run.
+func (vm *VM) Verify() error {
+	for _, w := range vm.words {
+		if w.body != nil {
+			if err := vm.verifyWord(w.body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verify ( -- ) runs Verify from forth
+func verify(vm *VM) error {
+	return vm.Verify()
+}
@@ -0,0 +1,76 @@
+package forth
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestVerifyGoodCode(t *testing.T) {
+	fvm := NewVM(WithPrelude(PreludeFull))
+	tstOutputOf(t, fvm, `: a 10 0 do i 2 mod if i . else 1 0 do loop then 2 +loop ;
+: b begin dup while 1 - repeat begin 1 + dup 5 > until exit ;
+: c dup if recur then ; : d postpone if ; immediate`)
+	if err := fvm.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyBadCode(t *testing.T) {
+	fvm := NewVM(WithPrelude(PreludeNone))
+	tstOutputOf(t, fvm, `: w 1 if 2 then ;`)
+	cw := fvm.words[fvm.dict["w"]].body
+	good := append([]uint32(nil), fvm.codeseg[cw.start:cw.end]...)
+
+	// w is: (litINT) 1 (bzr) 3 (litINT) 2 (RET)
+	for _, c := range []struct {
+		at     int
+		val    uint32
+		offset int
+	}{
+		{4, 60000, 4},    // not a word
+		{3, 30, 2},       // branch past the end
+		{3, 2, 2},        // branch into an operand
+		{6, opLitINT, 6}, // operand past the end
+		{6, opBranch, 6},
+	} {
+		copy(fvm.codeseg[cw.start:], good)
+		fvm.codeseg[cw.start+c.at] = c.val
+		var ve *VerifyError
+		if err := fvm.Verify(); !errors.As(err, &ve) || ve.Word != "w" || ve.Offset != c.offset || !errors.Is(err, ErrBadCode) {
+			t.Errorf("setting %d to %d: expected a verify error at %d, got %v", c.at, c.val, c.offset, err)
+		}
+	}
+
+	// a corrupt image isn't loaded
+	_, err := tstImage(t, fvm, nil)
+	if !errors.Is(err, ErrBadCode) {
+		t.Errorf("expected a bad image to fail, got %v", err)
+	}
+
+	// compile, can put anything into a definition, but ; won't
+	// define a word whose code can't be run
+	copy(fvm.codeseg[cw.start:], good)
+	for _, code := range []string{`: bad [ 1 compile, ] ;`, `: bad [ 10 compile, ] ; bad`} {
+		before := len(fvm.codeseg)
+		err = fvm.Run(strings.NewReader(code), ioutil.Discard)
+		var ve *VerifyError
+		if !errors.As(err, &ve) || ve.Word != "bad" || !errors.Is(err, ErrBadCode) {
+			t.Errorf("%s: expected ; to fail, got %v", code, err)
+		}
+		if _, ok := fvm.Lookup("bad"); ok || len(fvm.codeseg) != before {
+			t.Errorf("%s: the definition wasn't undone", code)
+		}
+	}
+	if err := fvm.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// (litPOOL) checks its entry, even in code which wasn't verified
+	fvm.codeseg = append(fvm.codeseg, opLitPOOL, 99)
+	fvm.ip = len(fvm.codeseg) - 2
+	if err := litPOOL(fvm); !errors.Is(err, ErrBadState) {
+		t.Errorf("expected a bad pool entry to fail, got %v", err)
+	}
+}
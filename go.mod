@@ -1 +1,3 @@
 module github.com/rwtodd/Go.Forth
+
+go 1.16